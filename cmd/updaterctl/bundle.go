@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/bundle"
+	"github.com/blitzh/go-autoupdater/pkg/service"
+	"github.com/blitzh/go-autoupdater/pkg/source"
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// runBundleCreate packs --manifest (url or local path) and its artifacts
+// into --bundle.
+func runBundleCreate(a cliArgs) int {
+	if a.manifestURL == "" || a.bundlePath == "" {
+		fmt.Println("bundle create needs --manifest and --bundle")
+		return 2
+	}
+	v, err := buildVerifier(a)
+	if err != nil {
+		fmt.Println("trusted keys:", err)
+		return 2
+	}
+
+	opt := bundle.CreateOptions{
+		Envelope:  a.envelope,
+		Platforms: a.platforms,
+		UserAgent: "portable-updater/1.0",
+		Client:    downloadClient(a),
+		Retry:     retryPolicy(a),
+		Logf: func(format string, args ...any) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+	if v != nil {
+		opt.Verifier = v
+	}
+
+	loc := a.manifestURL
+	if d, ok := localManifest(loc); ok {
+		opt.Manifest, opt.BaseDir = d, d.Dir
+		loc = filepath.Join(d.Dir, d.ManifestName)
+	} else if opt.Manifest, err = remoteSource(a, loc); err != nil {
+		fmt.Println("bundle create failed:", err)
+		return 2
+	}
+	if !a.envelope {
+		sigLoc := source.SignatureURL(loc)
+		if a.sigURL != "" {
+			sigLoc = a.sigURL
+		}
+		sig, err := rawSource(a, sigLoc)
+		if err != nil {
+			fmt.Println("bundle create failed:", err)
+			return 2
+		}
+		opt.Signature = sig
+		if a.sigURL == "" && v == nil {
+			// carry the signature along when there is one
+			opt.Signature = optionalRaw{sig}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	if err := bundle.Create(ctx, a.bundlePath, opt); err != nil {
+		fmt.Println("bundle create failed:", err)
+		return 1
+	}
+	fmt.Println("bundle written:", a.bundlePath)
+	return 0
+}
+
+// runBundleApply updates from --bundle without network access.
+func runBundleApply(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
+	if a.bundlePath == "" {
+		fmt.Println("missing --bundle")
+		return 2
+	}
+	tmp, err := os.MkdirTemp(a.installDir, ".bundle-")
+	if err != nil {
+		fmt.Println("bundle apply failed:", err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+
+	return update(a, ctrl, ap, func(v verify.SignatureVerifier) (updater.Source, error) {
+		return bundle.Open(a.bundlePath, tmp, v)
+	})
+}
+
+// optionalRaw treats a missing file as no signature; any other error is
+// returned so a flaky mirror cannot silently strip the signature.
+type optionalRaw struct{ source.RawSource }
+
+func (o optionalRaw) FetchRaw(ctx context.Context) ([]byte, error) {
+	b, err := o.RawSource.FetchRaw(ctx)
+	var se *util.HTTPStatusError
+	if errors.Is(err, os.ErrNotExist) || errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return b, err
+}
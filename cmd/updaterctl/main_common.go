@@ -2,24 +2,59 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/health"
 	"github.com/blitzh/go-autoupdater/pkg/service"
 	"github.com/blitzh/go-autoupdater/pkg/source"
 	"github.com/blitzh/go-autoupdater/pkg/updater"
 	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
 )
 
 type cliArgs struct {
+	cmd string // "" (update), "bundle create", "bundle apply", "rollback", "backups"
+
 	manifestURL string
 	installDir  string
 	exeName     string
 	curVer      string
 	logFile     string
+	stateFile   string
+	strictVer   bool
+
+	// product/channel checks and release selection from a manifest index
+	product    string
+	channel    string
+	pin        string
+	constraint string
+
+	// staged rollouts
+	deviceID      string
+	ignoreRollout bool
+
+	// post-update health checks
+	healthHTTP    []string
+	healthTCP     []string
+	healthCmd     string
+	healthActive  time.Duration
+	healthTimeout time.Duration
+
+	// signatures (optional)
+	pubKeys     []string
+	keyRingPath string
+	rotationURL string
+	sigURL      string
+	envelope    bool
+	requireSig  bool
 
 	// service-specific (may be unused on some OS builds)
 	svcName     string
@@ -27,35 +62,152 @@ type cliArgs struct {
 	systemdUnit string
 	launchdLbl  string
 
-	timeout time.Duration
+	timeout       time.Duration
+	retries       int
+	mirrorLatency bool
+
+	// S3-compatible storage for s3:// URLs
+	s3Endpoint  string
+	s3Region    string
+	s3PathStyle bool
+
+	// versioned backups and rollback
+	backupDir   string
+	backupKeep  int
+	backupMaxMB int64
+	rollbackTo  string
+
+	discardJournal bool
+
+	// bundle
+	bundlePath string
+	platforms  []string
 }
 
 func parseArgs() cliArgs {
 	var a cliArgs
 
-	flag.StringVar(&a.manifestURL, "manifest", "", "manifest.json url")
+	flag.StringVar(&a.manifestURL, "manifest", "", "manifest.json url (http(s):// or s3://), or a local directory / file path")
 	flag.StringVar(&a.installDir, "dir", ".", "install directory")
 	flag.StringVar(&a.exeName, "exe", "", "executable name (e.g. agent.exe / agent)")
 	flag.StringVar(&a.curVer, "current", "", "current version (optional)")
 	flag.StringVar(&a.logFile, "log", "", "log file path (optional)")
+	flag.BoolVar(&a.strictVer, "strict-semver", false, "require strict SemVer 2.0 versions")
+	flag.StringVar(&a.stateFile, "state", "", "anti-rollback state file (default: <dir>/<exe>.state.json)")
+	flag.StringVar(&a.product, "product", "", "refuse manifests for another product (optional)")
+	flag.StringVar(&a.channel, "channel", "", "release channel; more stable channels are accepted too (default: stable)")
+	flag.StringVar(&a.pin, "pin", "", "install exactly this version from a manifest index")
+	flag.StringVar(&a.constraint, "constraint", "", "version constraint for a manifest index, e.g. ~1.4")
+	flag.StringVar(&a.deviceID, "device-id", "", "device id for staged rollouts (default: random, kept in the state file)")
+	flag.BoolVar(&a.ignoreRollout, "ignore-rollout", false, "install even when this device is outside a staged rollout")
+
+	flag.Func("pubkey", "trusted ed25519 public key (base64/hex/PEM file); repeatable; enables manifest signature check", func(s string) error {
+		a.pubKeys = append(a.pubKeys, s)
+		return nil
+	})
+	flag.StringVar(&a.keyRingPath, "keyring", "", "key ring file; --pubkey keys bootstrap it and rotations are persisted to it")
+	flag.StringVar(&a.rotationURL, "rotation", "", "signed key rotation document url (needs --keyring)")
+	flag.StringVar(&a.sigURL, "sig", "", "detached manifest signature url (default: <manifest>.sig)")
+	flag.BoolVar(&a.envelope, "envelope", false, "manifest url serves a signed envelope instead of a detached signature")
+	flag.BoolVar(&a.requireSig, "require-artifact-sig", false, "refuse artifacts without a valid signature (needs --pubkey)")
 
 	flag.StringVar(&a.svcName, "service", "", "service name (windows) (optional)")
 	flag.StringVar(&a.nssmPath, "nssm", "", "path to nssm.exe (windows) (optional; use \"SC\" to force sc.exe)")
 	flag.StringVar(&a.systemdUnit, "systemd", "", "systemd unit (linux) (optional)")
 	flag.StringVar(&a.launchdLbl, "launchd", "", "launchd label (darwin) (optional)")
 
+	flag.Func("health-http", "after updating, expect a 2xx from this url; repeatable", func(s string) error {
+		a.healthHTTP = append(a.healthHTTP, s)
+		return nil
+	})
+	flag.Func("health-tcp", "after updating, expect host:port to accept connections; repeatable", func(s string) error {
+		a.healthTCP = append(a.healthTCP, s)
+		return nil
+	})
+	flag.StringVar(&a.healthCmd, "health-cmd", "", "after updating, expect this command to exit 0 (split on spaces)")
+	flag.DurationVar(&a.healthActive, "health-active", 0, "after updating, expect the service to stay active this long, e.g. 30s")
+	flag.DurationVar(&a.healthTimeout, "health-timeout", 60*time.Second, "roll back when health checks do not pass within this time")
+
 	flag.DurationVar(&a.timeout, "timeout", 120*time.Second, "update timeout")
+	flag.IntVar(&a.retries, "retries", 4, "attempts for manifest fetch and download (1 = no retry)")
+	flag.BoolVar(&a.mirrorLatency, "mirror-latency", false, "try artifact mirrors fastest-first instead of manifest order")
+
+	flag.StringVar(&a.s3Endpoint, "s3-endpoint", "", "S3-compatible endpoint for s3:// urls, e.g. http://minio:9000 (default: AWS)")
+	flag.StringVar(&a.s3Region, "s3-region", "", "S3 region (default: $AWS_REGION or us-east-1)")
+	flag.BoolVar(&a.s3PathStyle, "s3-path-style", false, "path-style S3 addressing (MinIO)")
+
+	flag.StringVar(&a.backupDir, "backup-dir", "", "versioned backups directory (default: <dir>/<exe>.backups)")
+	flag.IntVar(&a.backupKeep, "backup-keep", 3, "versioned backups to keep (0 disables)")
+	flag.Int64Var(&a.backupMaxMB, "backup-max-mb", 0, "total size limit for versioned backups in MiB (0 = none)")
+	flag.StringVar(&a.rollbackTo, "to", "", "rollback: version from the backups directory (default: <exe>.old)")
+	flag.BoolVar(&a.discardJournal, "discard-journal", false, "set aside an interrupted swap journal that cannot be recovered, without touching the binaries")
+
+	flag.StringVar(&a.bundlePath, "bundle", "", "bundle file (bundle create / bundle apply)")
+	flag.Func("platform", "os/arch to include in a bundle, e.g. linux/amd64; repeatable (default: all)", func(s string) error {
+		a.platforms = append(a.platforms, s)
+		return nil
+	})
 
-	flag.Parse()
+	// leading words before the first flag select a subcommand
+	args := os.Args[1:]
+	var words []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		words = append(words, args[0])
+		args = args[1:]
+	}
+	a.cmd = strings.Join(words, " ")
+	_ = flag.CommandLine.Parse(args)
 	return a
 }
 
+func run(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
+	if _, ok := ctrl.(service.StatusController); a.healthActive > 0 && !ok {
+		if f := serviceFlag(); f != "" {
+			fmt.Printf("--health-active needs a service manager that reports status; set %s\n", f)
+		} else {
+			fmt.Println("--health-active needs a service manager that reports status; this platform has none")
+		}
+		return 2
+	}
+	if a.rotationURL != "" && a.keyRingPath == "" {
+		fmt.Println("--rotation needs --keyring to persist the rotated keys")
+		return 2
+	}
+	switch a.cmd {
+	case "", "update", "bundle apply", "rollback":
+		// finish or undo a swap interrupted by a crash before changing anything
+		if code := recoverSwap(a, ctrl, ap); code != 0 {
+			return code
+		}
+	}
+	switch a.cmd {
+	case "", "update":
+		return runUpdate(a, ctrl, ap)
+	case "bundle create":
+		return runBundleCreate(a)
+	case "bundle apply":
+		return runBundleApply(a, ctrl, ap)
+	case "rollback":
+		return runRollback(a, ctrl, ap)
+	case "backups":
+		return runBackups(a)
+	}
+	fmt.Println("unknown command:", a.cmd)
+	return 2
+}
+
 func runUpdate(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
 	if a.manifestURL == "" {
 		fmt.Println("missing --manifest")
 		return 2
 	}
+	return update(a, ctrl, ap, func(v verify.SignatureVerifier) (updater.Source, error) {
+		return buildSource(a, v)
+	})
+}
 
+// update runs one update with the source returned by newSource.
+func update(a cliArgs, ctrl service.Controller, ap apply.Applier, newSource func(verify.SignatureVerifier) (updater.Source, error)) int {
 	if a.exeName == "" {
 		a.exeName = defaultExeName()
 	}
@@ -65,22 +217,67 @@ func runUpdate(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
 	}
 	logger := util.NewLogger(a.logFile)
 
-	src := source.NewHTTPManifestSource(a.manifestURL)
+	v, err := buildVerifier(a)
+	if err != nil {
+		fmt.Println("trusted keys:", err)
+		return 2
+	}
+	src, err := newSource(v)
+	if err != nil {
+		logger.Printf("source: %v", err)
+		fmt.Println("source:", err)
+		return 1
+	}
 
-	u := updater.New(updater.Config{
+	cfg := updater.Config{
 		CurrentVersion: a.curVer,
 		InstallDir:     a.installDir,
 		ExeName:        a.exeName,
+		StateFile:      a.stateFile,
+		Product:        a.product,
+		Channel:        a.channel,
+		Retry:          retryPolicy(a),
 		Source:         src,
 		Service:        ctrl,
 		Applier:        ap,
 		Logger:         logger,
-	})
+
+		PinVersion:        a.pin,
+		VersionConstraint: a.constraint,
+		DeviceID:          a.deviceID,
+		IgnoreRollout:     a.ignoreRollout,
+
+		HTTPClient: downloadClient(a),
+
+		RequireArtifactSignature: a.requireSig,
+	}
+	if v != nil {
+		cfg.ArtifactVerifier = v
+	}
+	if a.strictVer {
+		cfg.VersionComparer = updater.SemVerComparer{}
+	}
+	if a.mirrorLatency {
+		cfg.MirrorStrategy = updater.MirrorsByLatency
+	}
+	cfg.BackupDir, cfg.BackupKeep, cfg.BackupMaxBytes = backupConfig(a)
+	cfg.HealthChecks = healthChecks(a, ctrl)
+	cfg.HealthTimeout = a.healthTimeout
+	if isTerminal(os.Stdout) {
+		bar := &progressBar{w: os.Stdout, width: 30}
+		cfg.OnProgress = bar.update
+	}
+	u := updater.New(cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
 	defer cancel()
 
 	res, err := u.Update(ctx)
+	if errors.Is(err, updater.ErrRolledBack) {
+		logger.Printf("update rolled back: %v", err)
+		fmt.Println("update rolled back:", err)
+		return 3
+	}
 	if err != nil {
 		logger.Printf("update failed: %v", err)
 		fmt.Println("update failed:", err)
@@ -88,12 +285,183 @@ func runUpdate(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
 	}
 
 	if !res.DidUpdate {
+		if r := res.Rollout; r != nil {
+			logger.Printf("no update. remote=%s, rollout %.1f%%, device bucket %.2f", res.RemoteVersion, r.Percentage, r.Bucket)
+			fmt.Printf("no update. remote= %s (rollout %.1f%%, device bucket %.2f)\n", res.RemoteVersion, r.Percentage, r.Bucket)
+			return 0
+		}
 		logger.Printf("no update. remote=%s", res.RemoteVersion)
 		fmt.Println("no update. remote=", res.RemoteVersion)
 		return 0
 	}
 
-	logger.Printf("updated OK -> %s, backup=%s", res.RemoteVersion, res.OldBackupPath)
+	logger.Printf("updated OK -> %s, backup=%s, from=%s", res.RemoteVersion, res.OldBackupPath, res.MirrorURL)
 	fmt.Println("updated OK ->", res.RemoteVersion)
 	return 0
 }
+
+// backupConfig maps --backup-keep 0 to "disabled".
+func backupConfig(a cliArgs) (dir string, keep int, maxBytes int64) {
+	keep = a.backupKeep
+	if keep == 0 {
+		keep = -1
+	}
+	return a.backupDir, keep, a.backupMaxMB << 20
+}
+
+func healthChecks(a cliArgs, ctrl service.Controller) []health.Check {
+	var checks []health.Check
+	for _, u := range a.healthHTTP {
+		checks = append(checks, health.HTTP{URL: u})
+	}
+	for _, addr := range a.healthTCP {
+		checks = append(checks, health.TCP{Addr: addr})
+	}
+	if f := strings.Fields(a.healthCmd); len(f) > 0 {
+		checks = append(checks, health.Command{Name: f[0], Args: f[1:], Dir: a.installDir})
+	}
+	if a.healthActive > 0 {
+		checks = append(checks, health.ServiceActive{Service: ctrl, For: a.healthActive})
+	}
+	return checks
+}
+
+// buildVerifier returns nil when no keys were given.
+func buildVerifier(a cliArgs) (verify.SignatureVerifier, error) {
+	if len(a.pubKeys) == 0 && a.keyRingPath == "" {
+		return nil, nil
+	}
+	var keys []verify.TrustedKey
+	for _, s := range a.pubKeys {
+		// allow passing a key file instead of the key itself
+		if b, err := os.ReadFile(s); err == nil {
+			s = strings.TrimSpace(string(b))
+		}
+		k, err := verify.ParseEd25519PublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, verify.TrustedKey{PublicKey: k})
+	}
+	if a.keyRingPath != "" {
+		return verify.LoadKeyRing(a.keyRingPath, keys...)
+	}
+	return verify.NewKeyRing(keys...)
+}
+
+type manifestSource interface {
+	updater.Source
+	source.RawSource
+}
+
+func buildSource(a cliArgs, v verify.SignatureVerifier) (updater.Source, error) {
+	// --manifest may also be a local directory, file or file:// URL
+	loc := a.manifestURL
+	var man manifestSource
+	if d, ok := localManifest(loc); ok {
+		loc = filepath.Join(d.Dir, d.ManifestName)
+		man = d
+	} else {
+		var err error
+		if man, err = remoteSource(a, loc); err != nil {
+			return nil, err
+		}
+	}
+	if v == nil {
+		return man, nil
+	}
+
+	sigLoc := source.SignatureURL(loc)
+	if a.sigURL != "" {
+		sigLoc = a.sigURL
+	}
+	sig, err := rawSource(a, sigLoc)
+	if err != nil {
+		return nil, err
+	}
+	src := &source.SignedSource{
+		Manifest:  man,
+		Signature: sig,
+		Verifier:  v,
+	}
+	if a.envelope {
+		src.Signature = nil
+	}
+	if a.rotationURL != "" {
+		if src.Rotation, err = rawSource(a, a.rotationURL); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// localManifest returns a DirSource when loc is a directory, a manifest
+// file or a file:// URL.
+func localManifest(loc string) (*source.DirSource, bool) {
+	p, ok := localPath(loc)
+	if !ok {
+		return nil, false
+	}
+	if st, err := os.Stat(p); err == nil && st.IsDir() {
+		return source.NewDirSource(p), true
+	}
+	d := source.NewDirSource(filepath.Dir(p))
+	d.ManifestName = filepath.Base(p)
+	return d, true
+}
+
+func rawSource(a cliArgs, loc string) (source.RawSource, error) {
+	if p, ok := localPath(loc); ok {
+		return source.NewDirSource(filepath.Dir(p)).File(filepath.Base(p)), nil
+	}
+	return remoteSource(a, loc)
+}
+
+// remoteSource reads loc over HTTP(S), or from S3 for s3:// URLs.
+func remoteSource(a cliArgs, loc string) (manifestSource, error) {
+	if strings.HasPrefix(loc, "s3://") {
+		s, err := source.NewS3Source(loc, s3Config(a))
+		if err != nil {
+			return nil, err
+		}
+		s.Retry = retryPolicy(a)
+		return s, nil
+	}
+	return httpSource(a, loc), nil
+}
+
+// s3Config takes credentials from the AWS_* environment variables.
+func s3Config(a cliArgs) source.S3Config {
+	return source.S3Config{Endpoint: a.s3Endpoint, Region: a.s3Region, PathStyle: a.s3PathStyle}
+}
+
+// downloadClient fetches plain HTTP(S) as util.Downloader does by default,
+// response header timeout included, plus signed requests for s3:// URLs.
+func downloadClient(a cliArgs) *http.Client {
+	cfg := s3Config(a)
+	cfg.Base = util.NewDownloadTransport()
+	return &http.Client{Transport: cfg.Transport()}
+}
+
+// localPath accepts file:// URLs and plain paths.
+func localPath(loc string) (string, bool) {
+	if p, ok := util.LocalPath(loc); ok {
+		return p, true
+	}
+	if strings.Contains(loc, "://") {
+		return "", false
+	}
+	return loc, true
+}
+
+func httpSource(a cliArgs, url string) *source.HTTPManifestSource {
+	s := source.NewHTTPManifestSource(url)
+	s.Retry = retryPolicy(a)
+	return s
+}
+
+func retryPolicy(a cliArgs) *util.RetryPolicy {
+	p := util.DefaultRetryPolicy()
+	p.MaxAttempts = a.retries
+	return p
+}
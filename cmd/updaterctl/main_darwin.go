@@ -19,8 +19,10 @@ func main() {
 
 	ap := apply.PosixApplier{Retries: 40}
 
-	code := runUpdate(a, ctrl, ap)
+	code := run(a, ctrl, ap)
 	os.Exit(code)
 }
 
 func defaultExeName() string { return "agent" }
+
+func serviceFlag() string { return "--launchd" }
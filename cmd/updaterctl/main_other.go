@@ -13,8 +13,10 @@ func main() {
 	a := parseArgs()
 	ctrl := service.NoopController{}
 	ap := apply.PosixApplier{Retries: 40}
-	code := runUpdate(a, ctrl, ap)
+	code := run(a, ctrl, ap)
 	os.Exit(code)
 }
 
 func defaultExeName() string { return "agent" }
+
+func serviceFlag() string { return "" }
@@ -27,9 +27,11 @@ func main() {
 		// HelperPath default = <installDir>\updater-helper.exe (see applier implementation)
 	}
 
-	code := runUpdate(a, ctrl, ap)
+	code := run(a, ctrl, ap)
 	// exit code is handled by runtime implicitly
 	_ = code
 }
 
 func defaultExeName() string { return "agent.exe" }
+
+func serviceFlag() string { return "--service" }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+// progressBar renders util.Progress as a single self-overwriting line.
+type progressBar struct {
+	w     io.Writer
+	width int
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+func (b *progressBar) update(p util.Progress) {
+	line := "  " + humanBytes(p.Downloaded)
+	if p.Total > 0 {
+		frac := float64(p.Downloaded) / float64(p.Total)
+		if frac > 1 {
+			frac = 1
+		}
+		fill := int(frac * float64(b.width))
+		line = fmt.Sprintf("[%s%s] %5.1f%% %s/%s",
+			strings.Repeat("#", fill), strings.Repeat(".", b.width-fill),
+			frac*100, humanBytes(p.Downloaded), humanBytes(p.Total))
+	}
+	if p.BytesPerSec > 0 {
+		line += "  " + humanBytes(int64(p.BytesPerSec)) + "/s"
+	}
+	if p.ETA > 0 && !p.Done {
+		line += "  ETA " + p.ETA.Round(time.Second).String()
+	}
+	if p.Err != nil {
+		line += "  failed"
+	}
+
+	// pad to clear leftovers of a longer previous line; Done ends the line
+	// whether the download succeeded or failed
+	fmt.Fprintf(b.w, "\r%-79s", line)
+	if p.Done {
+		fmt.Fprintln(b.w)
+	}
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
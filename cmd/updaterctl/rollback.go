@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/service"
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+// runRollback restores the backup kept by the last update, or with --to a
+// version from the backups directory.
+func runRollback(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
+	u, logger := backupUpdater(a, ctrl, ap)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	var res *updater.RollbackResult
+	var err error
+	if a.rollbackTo != "" {
+		res, err = u.RollbackTo(ctx, a.rollbackTo)
+	} else {
+		res, err = u.Rollback(ctx)
+	}
+	if errors.Is(err, updater.ErrNoBackup) {
+		fmt.Println("rollback:", err)
+		return 1
+	}
+	if err != nil {
+		logger.Printf("rollback failed: %v", err)
+		fmt.Println("rollback failed:", err)
+		return 1
+	}
+	logger.Printf("rolled back %s -> %s", res.FromVersion, res.ToVersion)
+	fmt.Printf("rolled back %s -> %s\n", orUnknown(res.FromVersion), orUnknown(res.ToVersion))
+	return 0
+}
+
+// recoverSwap runs Updater.Recover, or with --discard-journal drops the
+// journal instead; non-zero means the command must not go on.
+func recoverSwap(a cliArgs, ctrl service.Controller, ap apply.Applier) int {
+	u, logger := backupUpdater(a, ctrl, ap)
+
+	if a.discardJournal {
+		stale, err := u.DiscardJournal()
+		if err != nil {
+			logger.Printf("discard journal: %v", err)
+			fmt.Println("discard journal:", err)
+			return 1
+		}
+		if stale != "" {
+			fmt.Println("discarded interrupted swap journal, kept as", stale)
+		}
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	res, err := u.Recover(ctx)
+	if errors.Is(err, updater.ErrRolledBack) {
+		logger.Printf("recover: %v", err)
+		fmt.Println("recover:", err)
+		return 0
+	}
+	if err != nil {
+		logger.Printf("recover failed: %v", err)
+		fmt.Println("recover failed:", err)
+		fmt.Println("(check the install directory, then rerun with --discard-journal to go on without recovering)")
+		return 1
+	}
+	if res != nil {
+		outcome := "undid"
+		switch {
+		case res.Discarded:
+			outcome = "binary was replaced outside the updater, journal set aside"
+		case res.Completed:
+			outcome = "completed"
+		}
+		fmt.Printf("recovered interrupted %s %s -> %s: %s (was at step %s)\n", res.Op,
+			orUnknown(res.FromVersion), orUnknown(res.ToVersion), outcome, res.Step)
+	}
+	return 0
+}
+
+// runBackups lists the versioned backups.
+func runBackups(a cliArgs) int {
+	u, _ := backupUpdater(a, service.NoopController{}, nil)
+	list, err := u.Backups()
+	if err != nil {
+		fmt.Println("backups:", err)
+		return 1
+	}
+	if len(list) == 0 {
+		fmt.Println("no backups")
+		return 0
+	}
+	for _, b := range list {
+		fmt.Printf("%-20s installed %s  backed up %s  %d bytes  sha256 %s\n", orUnknown(b.Version),
+			b.InstalledAt.Local().Format("2006-01-02 15:04"), b.BackedUpAt.Local().Format("2006-01-02 15:04"), b.Size, b.SHA256)
+	}
+	return 0
+}
+
+func backupUpdater(a cliArgs, ctrl service.Controller, ap apply.Applier) (*updater.Updater, *util.Logger) {
+	if a.exeName == "" {
+		a.exeName = defaultExeName()
+	}
+	if a.logFile == "" {
+		a.logFile = filepath.Join(a.installDir, "updaterctl.log")
+	}
+	logger := util.NewLogger(a.logFile)
+
+	cfg := updater.Config{
+		CurrentVersion: a.curVer,
+		InstallDir:     a.installDir,
+		ExeName:        a.exeName,
+		StateFile:      a.stateFile,
+		Service:        ctrl,
+		Applier:        ap,
+		Logger:         logger,
+	}
+	cfg.BackupDir, cfg.BackupKeep, cfg.BackupMaxBytes = backupConfig(a)
+	cfg.HealthChecks = healthChecks(a, ctrl) // rerun when Recover completes an update
+	cfg.HealthTimeout = a.healthTimeout
+	if a.strictVer {
+		cfg.VersionComparer = updater.SemVerComparer{}
+	}
+	return updater.New(cfg), logger
+}
+
+func orUnknown(v string) string {
+	if v == "" {
+		return "(unknown)"
+	}
+	return v
+}
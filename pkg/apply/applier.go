@@ -9,3 +9,29 @@ import (
 type Applier interface {
 	Apply(ctx context.Context, svc service.Controller, currentPath, newPath, oldPath string) (oldBackup string, err error)
 }
+
+// Steps reported by appliers that swap the files themselves, in order;
+// StepRestore only on failure.
+const (
+	StepStop        = "stop"
+	StepMoveCurrent = "current->old"
+	StepMoveNew     = "new->current"
+	StepStart       = "start"
+	StepRestore     = "old->current"
+)
+
+type stepKey struct{}
+
+// WithStepFunc returns a context for Apply that reports each step to f
+// before it is taken, e.g. to journal the swap.
+func WithStepFunc(ctx context.Context, f func(step string)) context.Context {
+	return context.WithValue(ctx, stepKey{}, f)
+}
+
+// ReportStep passes step to the function set by WithStepFunc, if any.
+// Appliers that move the files themselves call it before each step.
+func ReportStep(ctx context.Context, step string) {
+	if f, ok := ctx.Value(stepKey{}).(func(string)); ok && f != nil {
+		f(step)
+	}
+}
@@ -20,24 +20,30 @@ func (a PosixApplier) Apply(ctx context.Context, svc service.Controller, current
 	}
 
 	// stop service/process if provided
+	ReportStep(ctx, StepStop)
 	_ = svc.Stop(ctx)
 
 	// best-effort remove old
 	_ = util.RemoveWithRetry(oldPath, a.Retries, 200*time.Millisecond)
 
 	// rename current->old (if exists)
+	ReportStep(ctx, StepMoveCurrent)
 	_ = util.RenameWithRetry(currentPath, oldPath, a.Retries, 200*time.Millisecond)
 
 	// rename new->current
+	ReportStep(ctx, StepMoveNew)
 	if err := util.RenameWithRetry(newPath, currentPath, a.Retries, 200*time.Millisecond); err != nil {
 		// rollback: old->current
+		ReportStep(ctx, StepRestore)
 		_ = util.RenameWithRetry(oldPath, currentPath, a.Retries, 200*time.Millisecond)
 		return "", err
 	}
 
 	// start again
+	ReportStep(ctx, StepStart)
 	if err := svc.Start(ctx); err != nil {
 		// rollback
+		ReportStep(ctx, StepRestore)
 		_ = svc.Stop(ctx)
 		_ = util.RemoveWithRetry(currentPath, a.Retries, 200*time.Millisecond)
 		_ = util.RenameWithRetry(oldPath, currentPath, a.Retries, 200*time.Millisecond)
@@ -0,0 +1,379 @@
+// Package bundle packs a manifest, its signature and the referenced
+// artifacts into one tar file for offline installs, and serves it back as an
+// updater.Source.
+//
+// The manifest is stored byte-for-byte so its signature still verifies.
+// Artifacts with relative URLs keep their path inside the bundle; absolute
+// URLs are mapped to bundled files through bundle.json.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/source"
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+const (
+	IndexName     = "bundle.json"
+	manifestName  = "manifest.json"
+	signatureName = "manifest.json.sig"
+)
+
+// Index is stored as bundle.json.
+type Index struct {
+	Format    int               `json:"format"` // 1
+	CreatedAt time.Time         `json:"created_at"`
+	Manifest  string            `json:"manifest"`
+	Signature string            `json:"signature,omitempty"` // detached; empty for envelopes and unsigned bundles
+	Envelope  bool              `json:"envelope,omitempty"`
+	Files     map[string]string `json:"files,omitempty"` // original absolute URL -> path in bundle
+}
+
+type CreateOptions struct {
+	// Manifest returns the manifest or envelope exactly as published;
+	// Signature the detached signature (nil for envelopes or unsigned).
+	Manifest  source.RawSource
+	Signature source.RawSource
+	Envelope  bool
+
+	// BaseDir resolves relative artifact URLs (a manifest read from disk).
+	BaseDir string
+
+	// Verifier, if set, must accept the manifest before anything is bundled.
+	Verifier verify.SignatureVerifier
+
+	// Platforms limits bundled artifacts and patches, e.g. "linux/amd64";
+	// empty means all.
+	Platforms []string
+
+	UserAgent string
+	Client    *http.Client // optional, e.g. to sign s3:// requests
+	Retry     *util.RetryPolicy
+	Logf      func(format string, a ...any)
+}
+
+// Create writes the bundle to out (via out.part).
+func Create(ctx context.Context, out string, opt CreateOptions) error {
+	if opt.Manifest == nil {
+		return errors.New("bundle: Manifest source is nil")
+	}
+	logf := opt.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+
+	raw, err := opt.Manifest.FetchRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("fetch manifest: %w", err)
+	}
+	var sig []byte
+	if opt.Signature != nil && !opt.Envelope {
+		if sig, err = opt.Signature.FetchRaw(ctx); err != nil {
+			return fmt.Errorf("fetch manifest signature: %w", err)
+		}
+	}
+
+	m, err := decode(ctx, raw, sig, opt)
+	if err != nil {
+		return err
+	}
+
+	work, err := os.MkdirTemp(filepath.Dir(out), ".bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	b := &builder{ctx: ctx, opt: opt, work: work, logf: logf,
+		idx: Index{Format: 1, CreatedAt: time.Now().UTC(), Manifest: manifestName, Envelope: opt.Envelope, Files: map[string]string{}}}
+	if len(sig) > 0 {
+		b.idx.Signature = signatureName
+	}
+
+	// an index bundles every release it lists
+	all := append([]updater.Manifest{*m}, m.Releases...)
+
+	n := 0
+	for _, a := range artifacts(all) {
+		if !b.wanted(a.OS, a.Arch) {
+			continue
+		}
+		urls := []string{a.URL}
+		for _, mr := range a.Mirrors {
+			urls = append(urls, mr.URL)
+		}
+		if err := b.addFile(urls, a.SHA256); err != nil {
+			return fmt.Errorf("artifact %s/%s: %w", a.OS, a.Arch, err)
+		}
+		if a.SignatureURL != "" {
+			if err := b.addFile([]string{a.SignatureURL}, ""); err != nil {
+				return fmt.Errorf("artifact %s/%s signature: %w", a.OS, a.Arch, err)
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return errors.New("bundle: no artifacts match the requested platforms")
+	}
+	for _, p := range patches(all) {
+		if !b.wanted(p.OS, p.Arch) {
+			continue
+		}
+		if err := b.addFile([]string{p.URL}, p.SHA256); err != nil {
+			// patches are optional; the full artifact is bundled anyway
+			logf("skipping patch %s: %v", p.URL, err)
+		}
+	}
+
+	return b.write(out, raw, sig)
+}
+
+func artifacts(ms []updater.Manifest) []updater.Artifact {
+	var out []updater.Artifact
+	for _, m := range ms {
+		out = append(out, m.Artifacts...)
+	}
+	return out
+}
+
+func patches(ms []updater.Manifest) []updater.Patch {
+	var out []updater.Patch
+	for _, m := range ms {
+		out = append(out, m.Patches...)
+	}
+	return out
+}
+
+func decode(ctx context.Context, raw, sig []byte, opt CreateOptions) (*updater.Manifest, error) {
+	if opt.Verifier != nil {
+		ss := &source.SignedSource{Manifest: rawBytes(raw), Verifier: opt.Verifier}
+		if !opt.Envelope {
+			ss.Signature = rawBytes(sig)
+		}
+		return ss.Fetch(ctx)
+	}
+	body := raw
+	if opt.Envelope {
+		var env source.Envelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			return nil, fmt.Errorf("decode manifest envelope: %w", err)
+		}
+		body = env.Signed
+	}
+	var m updater.Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+type rawBytes []byte
+
+func (r rawBytes) FetchRaw(context.Context) ([]byte, error) { return r, nil }
+
+type builder struct {
+	ctx   context.Context
+	opt   CreateOptions
+	work  string
+	logf  func(string, ...any)
+	idx   Index
+	files []string // bundle paths in write order
+}
+
+func (b *builder) wanted(osName, arch string) bool {
+	if len(b.opt.Platforms) == 0 {
+		return true
+	}
+	for _, p := range b.opt.Platforms {
+		if strings.EqualFold(p, osName+"/"+arch) {
+			return true
+		}
+	}
+	return false
+}
+
+// addFile fetches the first working URL of urls into the bundle, checking
+// sha256 when given. Every absolute URL is mapped to the bundled file.
+func (b *builder) addFile(urls []string, sha string) error {
+	var errs []error
+	for _, u := range urls {
+		if u == "" {
+			continue
+		}
+		if name, ok := b.idx.Files[indexKey(u)]; ok {
+			return b.mapAll(urls, name)
+		}
+		src, name, err := b.locate(u, sha)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(b.work, filepath.FromSlash(name))
+		if _, err := os.Stat(dst); err == nil {
+			return b.mapAll(urls, name)
+		}
+
+		b.logf("bundling %s", u)
+		dl := util.Downloader{UserAgent: b.opt.UserAgent, Client: b.opt.Client, Retry: b.opt.Retry}
+		if err := dl.Download(b.ctx, src, dst); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+			continue
+		}
+		if sha != "" {
+			if err := verify.VerifyFileSHA256(dst, sha); err != nil {
+				_ = os.Remove(dst)
+				errs = append(errs, fmt.Errorf("%s: %w", u, err))
+				continue
+			}
+		}
+		b.files = append(b.files, name)
+		return b.mapAll(urls, name)
+	}
+	if len(errs) == 0 {
+		return errors.New("no url")
+	}
+	return errors.Join(errs...)
+}
+
+func (b *builder) mapAll(urls []string, name string) error {
+	for _, u := range urls {
+		if u != "" && !isRelative(u) {
+			b.idx.Files[indexKey(u)] = name
+		}
+	}
+	return nil
+}
+
+// locate returns where to fetch u from and its path inside the bundle.
+// Relative URLs keep their path so the bundled manifest resolves them as is.
+func (b *builder) locate(u, sha string) (src, name string, err error) {
+	if isRelative(u) {
+		name = path.Clean(strings.ReplaceAll(u, "\\", "/"))
+		if !filepath.IsLocal(filepath.FromSlash(name)) || name == IndexName || name == manifestName || name == signatureName {
+			return "", "", fmt.Errorf("unsafe relative url %q", u)
+		}
+		if b.opt.BaseDir == "" {
+			return "", "", fmt.Errorf("relative url %q needs a manifest directory", u)
+		}
+		dir, err := filepath.Abs(b.opt.BaseDir)
+		if err != nil {
+			return "", "", err
+		}
+		return util.FileURL(filepath.Join(dir, filepath.FromSlash(name))), name, nil
+	}
+
+	base := "file"
+	if pu, err := url.Parse(u); err == nil && path.Base(pu.Path) != "." && path.Base(pu.Path) != "/" {
+		base = path.Base(pu.Path)
+	}
+	key := sha
+	if key == "" {
+		h := sha256.Sum256([]byte(u))
+		key = hex.EncodeToString(h[:])
+	}
+	key = strings.TrimPrefix(strings.ToLower(key), "sha256:")
+	if len(key) > 16 {
+		key = key[:16]
+	}
+	return indexKey(u), "files/" + key + "/" + base, nil
+}
+
+func (b *builder) write(out string, raw, sig []byte) error {
+	idx, err := json.MarshalIndent(b.idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := out + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = func() error {
+		if err := writeEntry(tw, IndexName, idx); err != nil {
+			return err
+		}
+		if err := writeEntry(tw, manifestName, raw); err != nil {
+			return err
+		}
+		if len(sig) > 0 {
+			if err := writeEntry(tw, signatureName, sig); err != nil {
+				return err
+			}
+		}
+		for _, name := range b.files {
+			if err := writeFileEntry(tw, name, filepath.Join(b.work, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return util.RenameWithRetry(tmp, out, 30, 250*time.Millisecond)
+}
+
+func writeEntry(tw *tar.Writer, name string, b []byte) error {
+	h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+func writeFileEntry(tw *tar.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := &tar.Header{Name: name, Mode: 0644, Size: st.Size(), ModTime: st.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func isRelative(u string) bool {
+	return !strings.Contains(u, "://") && !strings.HasPrefix(strings.ToLower(u), "file:") && !filepath.IsAbs(filepath.FromSlash(u))
+}
+
+// indexKey is the URL as source.DirSource reports it: absolute paths
+// become file:// URLs, everything else is unchanged.
+func indexKey(u string) string {
+	if !strings.Contains(u, "://") && !strings.HasPrefix(strings.ToLower(u), "file:") {
+		return util.FileURL(filepath.FromSlash(u))
+	}
+	return u
+}
@@ -0,0 +1,290 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/service"
+	"github.com/blitzh/go-autoupdater/pkg/source"
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+const (
+	prevBin  = "previous binary"
+	nextBin  = "next binary"
+	otherBin = "binary for another platform"
+	patchBin = "patch bytes"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// renameApplier swaps the files without touching a service.
+type renameApplier struct{}
+
+func (renameApplier) Apply(ctx context.Context, svc service.Controller, currentPath, newPath, oldPath string) (string, error) {
+	_ = os.Remove(oldPath)
+	if err := os.Rename(currentPath, oldPath); err != nil {
+		return "", err
+	}
+	return oldPath, os.Rename(newPath, currentPath)
+}
+
+// publish writes a signed manifest to a new directory: a relative artifact
+// for this platform, one on srv for another platform and a patch on srv.
+func publish(t *testing.T, priv ed25519.PrivateKey, srvURL string) string {
+	t.Helper()
+	m := updater.Manifest{
+		Product: "agent",
+		Version: "2.0.0",
+		Artifacts: []updater.Artifact{
+			{OS: runtime.GOOS, Arch: runtime.GOARCH, URL: "bin/agent_2.0.0", SHA256: sha256Hex(nextBin), Size: int64(len(nextBin))},
+			{OS: "plan9", Arch: "386", URL: srvURL + "/agent_plan9", SHA256: sha256Hex(otherBin),
+				Mirrors: []updater.Mirror{{URL: srvURL + "/mirror/agent_plan9"}}},
+		},
+		Patches: []updater.Patch{
+			{OS: runtime.GOOS, Arch: runtime.GOARCH, FromSHA256: sha256Hex("an older build"), URL: srvURL + "/agent.patch", SHA256: sha256Hex(patchBin)},
+			{OS: runtime.GOOS, Arch: runtime.GOARCH, FromSHA256: sha256Hex("an older build"), URL: srvURL + "/missing.patch"},
+		},
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"manifest.json":     string(raw),
+		"manifest.json.sig": string(ed25519.Sign(priv, raw)),
+		"bin/agent_2.0.0":   nextBin,
+	})
+	return dir
+}
+
+func TestCreateOpenApply(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agent_plan9", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(otherBin)) })
+	mux.HandleFunc("/agent.patch", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(patchBin)) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		platforms []string
+		wantArts  int
+		wantPatch bool
+	}{
+		{"all platforms", nil, 2, true},
+		{"this platform", []string{runtime.GOOS + "/" + runtime.GOARCH}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubDir := publish(t, priv, srv.URL)
+			d := source.NewDirSource(pubDir)
+			out := filepath.Join(t.TempDir(), "agent.bundle")
+			err := Create(context.Background(), out, CreateOptions{
+				Manifest:  d,
+				Signature: d.File("manifest.json.sig"),
+				BaseDir:   pubDir,
+				Verifier:  verify.NewEd25519Verifier(pub),
+				Platforms: tt.platforms,
+				Retry:     &util.RetryPolicy{MaxAttempts: 1},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the publisher and server are gone for the install
+			if err := os.RemoveAll(pubDir); err != nil {
+				t.Fatal(err)
+			}
+			extracted := t.TempDir()
+			s, err := Open(out, extracted, verify.NewEd25519Verifier(pub))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := s.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Artifacts) != tt.wantArts || (len(m.Patches) == 1) != tt.wantPatch {
+				t.Fatalf("bundled %d artifacts, %d patches; want %d, patch %v", len(m.Artifacts), len(m.Patches), tt.wantArts, tt.wantPatch)
+			}
+			for _, a := range m.Artifacts {
+				p, ok := util.LocalPath(a.URL)
+				if !ok || !strings.HasPrefix(p, extracted) || len(a.Mirrors) != 0 {
+					t.Fatalf("artifact %s/%s at %s (mirrors %v), want a file in the bundle", a.OS, a.Arch, a.URL, a.Mirrors)
+				}
+				if err := verify.VerifyFileSHA256(p, a.SHA256); err != nil {
+					t.Fatalf("artifact %s/%s: %v", a.OS, a.Arch, err)
+				}
+			}
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"agent": prevBin})
+			res, err := updater.New(updater.Config{
+				CurrentVersion: "1.0.0",
+				InstallDir:     dir,
+				ExeName:        "agent",
+				Source:         s,
+				Applier:        renameApplier{},
+				MinBytes:       1,
+				BackupKeep:     -1,
+			}).Update(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.DidUpdate {
+				t.Fatalf("result = %+v", res)
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, "agent")); string(got) != nextBin {
+				t.Fatalf("installed %q, want the bundled binary", got)
+			}
+		})
+	}
+}
+
+func TestCreateRejects(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+		opt   func(*CreateOptions)
+	}{
+		{"bad signature", func(t *testing.T, dir string) {
+			raw, _ := os.ReadFile(filepath.Join(dir, "manifest.json"))
+			writeFiles(t, dir, map[string]string{"manifest.json.sig": string(ed25519.Sign(otherPriv, raw))})
+		}, nil},
+		{"artifact hash mismatch", func(t *testing.T, dir string) {
+			writeFiles(t, dir, map[string]string{"bin/agent_2.0.0": "tampered"})
+		}, func(o *CreateOptions) { o.Platforms = []string{runtime.GOOS + "/" + runtime.GOARCH} }},
+		{"artifact not served", nil, func(o *CreateOptions) { o.Platforms = []string{"plan9/386"} }},
+		{"no matching platform", nil, func(o *CreateOptions) { o.Platforms = []string{"aix/ppc64"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubDir := publish(t, priv, srv.URL)
+			if tt.setup != nil {
+				tt.setup(t, pubDir)
+			}
+			d := source.NewDirSource(pubDir)
+			opt := CreateOptions{
+				Manifest:  d,
+				Signature: d.File("manifest.json.sig"),
+				BaseDir:   pubDir,
+				Verifier:  verify.NewEd25519Verifier(pub),
+				Retry:     &util.RetryPolicy{MaxAttempts: 1},
+			}
+			if tt.opt != nil {
+				tt.opt(&opt)
+			}
+			out := filepath.Join(t.TempDir(), "agent.bundle")
+			if err := Create(context.Background(), out, opt); err == nil {
+				t.Fatal("want error")
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Fatal("bundle written despite the error")
+			}
+		})
+	}
+}
+
+// writeBundle writes a tar with the given entries in order.
+func writeBundle(t *testing.T, entries ...[2]string) string {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "agent.bundle")
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		if err := writeEntry(tw, e[0], []byte(e[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestOpenRejectsUnsafePaths(t *testing.T) {
+	index := func(idx Index) string {
+		idx.Format = 1
+		b, _ := json.Marshal(idx)
+		return string(b)
+	}
+	tests := []struct {
+		name    string
+		entries [][2]string
+	}{
+		{"entry outside dir", [][2]string{{IndexName, index(Index{Manifest: manifestName})}, {"../evil", "x"}}},
+		{"absolute entry", [][2]string{{IndexName, index(Index{Manifest: manifestName})}, {"/etc/evil", "x"}}},
+		{"file mapped outside dir", [][2]string{{IndexName, index(Index{Manifest: manifestName, Files: map[string]string{"https://example.com/agent": "../../agent"}})}}},
+		{"manifest outside dir", [][2]string{{IndexName, index(Index{Manifest: "../manifest.json"})}}},
+		{"signature outside dir", [][2]string{{IndexName, index(Index{Manifest: manifestName, Signature: "/tmp/manifest.json.sig"})}}},
+		{"no index", [][2]string{{manifestName, "{}"}}},
+		{"unknown format", [][2]string{{IndexName, `{"format":2}`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "x")
+			if _, err := Open(writeBundle(t, tt.entries...), dir, nil); err == nil {
+				t.Fatal("want error")
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil")); err == nil {
+				t.Fatal("entry written outside the bundle directory")
+			}
+		})
+	}
+
+	// a Source built by hand is checked too
+	s := &Source{Dir: t.TempDir(), Index: Index{Format: 1, Manifest: manifestName, Files: map[string]string{"https://example.com/agent": "../agent"}}}
+	if _, err := s.Fetch(context.Background()); err == nil {
+		t.Fatal("Fetch accepted an index pointing outside the bundle")
+	}
+}
@@ -0,0 +1,229 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/blitzh/go-autoupdater/pkg/source"
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// MaxEntryBytes bounds a single file extracted from a bundle.
+var MaxEntryBytes int64 = 2 << 30
+
+// Source serves an extracted bundle. Every artifact, mirror, patch and
+// signature URL is rewritten to the bundled file; anything not in the
+// bundle is dropped, so an update from it never touches the network.
+type Source struct {
+	Dir      string
+	Index    Index
+	Verifier verify.SignatureVerifier // nil: manifest signature not checked
+}
+
+// Open extracts the bundle at path into dir.
+func Open(bundlePath, dir string, v verify.SignatureVerifier) (*Source, error) {
+	idx, err := Extract(bundlePath, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Dir: dir, Index: *idx, Verifier: v}, nil
+}
+
+// Extract unpacks regular files from the bundle into dir and returns its
+// index. Entries that would land outside dir are rejected.
+func Extract(bundlePath, dir string) (*Index, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var idx *Index
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %w", err)
+		}
+		if h.Typeflag == tar.TypeDir {
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("bundle entry %q is not a regular file", h.Name)
+		}
+		name, ok := localName(h.Name)
+		if !ok {
+			return nil, fmt.Errorf("unsafe bundle entry name %q", h.Name)
+		}
+		if h.Size > MaxEntryBytes {
+			return nil, fmt.Errorf("bundle entry %q too large: %d bytes", h.Name, h.Size)
+		}
+
+		if name == IndexName {
+			var i Index
+			if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&i); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", IndexName, err)
+			}
+			idx = &i
+			continue
+		}
+		if err := extractEntry(tr, filepath.Join(dir, filepath.FromSlash(name)), h.Size); err != nil {
+			return nil, err
+		}
+	}
+	if idx == nil {
+		return nil, fmt.Errorf("bundle has no %s", IndexName)
+	}
+	if idx.Format != 1 {
+		return nil, fmt.Errorf("unsupported bundle format %d", idx.Format)
+	}
+	if err := idx.check(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// localName cleans a slash-separated bundle path and reports whether it
+// stays inside the bundle directory.
+func localName(name string) (string, bool) {
+	name = path.Clean(name)
+	return name, filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// check rejects index paths that would point outside the bundle.
+func (idx *Index) check() error {
+	names := []string{idx.Manifest}
+	if idx.Signature != "" {
+		names = append(names, idx.Signature)
+	}
+	for _, name := range idx.Files {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, ok := localName(name); !ok {
+			return fmt.Errorf("unsafe path %q in %s", name, IndexName)
+		}
+	}
+	return nil
+}
+
+func extractEntry(r io.Reader, dst string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, size))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *Source) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if err := s.Index.check(); err != nil {
+		return nil, err
+	}
+	d := source.NewDirSource(s.Dir)
+	d.ManifestName = s.Index.Manifest
+
+	var src updater.Source = d
+	if s.Verifier != nil {
+		ss := &source.SignedSource{Manifest: d, Verifier: s.Verifier}
+		if !s.Index.Envelope {
+			if s.Index.Signature == "" {
+				return nil, errors.New("bundle manifest is not signed")
+			}
+			ss.Signature = d.File(s.Index.Signature)
+		}
+		src = ss
+	} else if s.Index.Envelope {
+		return nil, errors.New("bundle manifest is an envelope; a Verifier is required")
+	}
+
+	m, err := src.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.localize(m)
+}
+
+// localize points every URL at the bundled copy. Index paths were
+// checked by Fetch.
+func (s *Source) localize(m *updater.Manifest) (*updater.Manifest, error) {
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	local := func(u string) string {
+		if u == "" {
+			return ""
+		}
+		if name, ok := s.Index.Files[u]; ok {
+			return util.FileURL(filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		// relative URLs were resolved into dir by DirSource
+		if p, ok := util.LocalPath(u); ok {
+			if rel, err := filepath.Rel(dir, p); err == nil && filepath.IsLocal(rel) {
+				return u
+			}
+		}
+		return ""
+	}
+
+	arts := m.Artifacts[:0]
+	for _, a := range m.Artifacts {
+		url := local(a.URL)
+		for _, mr := range a.Mirrors {
+			if url == "" {
+				url = local(mr.URL)
+			}
+		}
+		if url == "" {
+			continue
+		}
+		a.URL, a.Mirrors = url, nil
+		a.SignatureURL = local(a.SignatureURL)
+		arts = append(arts, a)
+	}
+	m.Artifacts = arts
+
+	patches := m.Patches[:0]
+	for _, p := range m.Patches {
+		if p.URL = local(p.URL); p.URL != "" {
+			patches = append(patches, p)
+		}
+	}
+	m.Patches = patches
+
+	// index: drop releases with nothing bundled
+	releases := m.Releases[:0]
+	for i := range m.Releases {
+		r, err := s.localize(&m.Releases[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Artifacts) > 0 {
+			releases = append(releases, *r)
+		}
+	}
+	m.Releases = releases
+	return m, nil
+}
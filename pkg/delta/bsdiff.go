@@ -0,0 +1,87 @@
+// Package delta applies binary patches between two builds of an executable.
+package delta
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const bsdiffMagic = "BSDIFF40"
+
+// MaxNewSize bounds the output a patch may declare, so a corrupt or hostile
+// header cannot make ApplyBSDiff allocate unbounded memory.
+var MaxNewSize int64 = 2 << 30
+
+var ErrCorruptPatch = errors.New("corrupt bsdiff patch")
+
+// ApplyBSDiff applies a classic bsdiff 4.x patch (BSDIFF40 header,
+// bzip2-compressed control, diff and extra blocks) to old.
+func ApplyBSDiff(old []byte, patch []byte) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != bsdiffMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorruptPatch)
+	}
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+	bodyLen := int64(len(patch)) - 32
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || newSize > MaxNewSize ||
+		ctrlLen > bodyLen || diffLen > bodyLen-ctrlLen {
+		return nil, fmt.Errorf("%w: bad lengths", ErrCorruptPatch)
+	}
+
+	body := patch[32:]
+	ctrl := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+
+	out := make([]byte, newSize)
+	oldSize := int64(len(old))
+	var newPos, oldPos int64
+	var buf [24]byte
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, buf[:]); err != nil {
+			return nil, fmt.Errorf("%w: control block: %v", ErrCorruptPatch, err)
+		}
+		x, y, z := offtin(buf[0:8]), offtin(buf[8:16]), offtin(buf[16:24])
+		// written as differences so huge values cannot overflow
+		if x < 0 || y < 0 || x > newSize-newPos {
+			return nil, fmt.Errorf("%w: bad control entry", ErrCorruptPatch)
+		}
+
+		// diff block: bytes added to the old data
+		if _, err := io.ReadFull(diff, out[newPos:newPos+x]); err != nil {
+			return nil, fmt.Errorf("%w: diff block: %v", ErrCorruptPatch, err)
+		}
+		for i := int64(0); i < x; i++ {
+			if p := oldPos + i; p >= 0 && p < oldSize {
+				out[newPos+i] += old[p]
+			}
+		}
+		newPos += x
+		oldPos += x
+
+		// extra block: new bytes copied verbatim
+		if y > newSize-newPos {
+			return nil, fmt.Errorf("%w: bad control entry", ErrCorruptPatch)
+		}
+		if _, err := io.ReadFull(extra, out[newPos:newPos+y]); err != nil {
+			return nil, fmt.Errorf("%w: extra block: %v", ErrCorruptPatch, err)
+		}
+		newPos += y
+		oldPos += z
+	}
+	return out, nil
+}
+
+// offtin decodes bsdiff's sign-magnitude little-endian int64.
+func offtin(b []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
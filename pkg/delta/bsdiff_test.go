@@ -0,0 +1,126 @@
+package delta
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// bzip2-compressed blocks; Go has no bzip2 writer, so they were made with
+// Python's bz2.compress. Control entries are (x, y, z) triples.
+const (
+	bzEmpty = "425a683917724538509000000000"
+	bzZero4 = "425a683931415926535938fb2284000002400040002000211846b0bb9229c28481c7d91420" // 4 zero bytes
+
+	// "The quick brown fox jumps over the lazy dog" -> "The quick brown cat jumps over the lazy DOG!!"
+	bzBasicCtrl  = "425a6839314159265359f4f542ee00000e600058084040200021b534c0c02d8a4019c2af0bb9229c28487a7aa17700" // (16,3,3) (24,2,0)
+	bzBasicDiff  = "425a68393141592653590a1461bc0000005001400020004000200030cc09324825c5dc914e14240285186f00"       // 16 zeros, then "dog"->"DOG" deltas
+	bzBasicExtra = "425a6839314159265359b84e35f700000191802000280004002000219a68334d111e2ee48a70a121709c6bee"       // "cat!!"
+
+	bzCtrlOverflowX = "425a6839314159265359ffd5c340000004e080cc04080000008000a000310c00c9825681d50acef177245385090ffd5c3400" // (4,0,0) (MaxInt64,0,0)
+	bzCtrlOverflowY = "425a6839314159265359c4b540bb0000054080cc0000008000a00030cd011b4d256f4c2661e2ee48a70a121896a81760"     // (4,MaxInt64,0)
+	bzCtrlShort     = "425a68393141592653595a2ce8ba000002600044000800200030cc0cf505ce2ee48a70a120b459d174"                   // (4,0,0)
+	bzCtrlX8        = "425a6839314159265359b8ad553b000002600040400800200030cc0cf505ce2ee48a70a121715aaa76"                   // (8,0,0)
+)
+
+// patch assembles a BSDIFF40 patch from hex-encoded compressed blocks.
+func patch(t *testing.T, newSize int64, ctrl, diff, extra string) []byte {
+	t.Helper()
+	var blocks [3][]byte
+	for i, h := range []string{ctrl, diff, extra} {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[i] = b
+	}
+	p := append([]byte(bsdiffMagic), header(int64(len(blocks[0])), int64(len(blocks[1])), newSize)...)
+	for _, b := range blocks {
+		p = append(p, b...)
+	}
+	return p
+}
+
+func header(vals ...int64) []byte {
+	var out []byte
+	for _, v := range vals {
+		out = append(out, offtout(v)...)
+	}
+	return out
+}
+
+func offtout(v int64) []byte {
+	b := make([]byte, 8)
+	if v < 0 {
+		binary.LittleEndian.PutUint64(b, uint64(-v)|1<<63)
+	} else {
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	}
+	return b
+}
+
+func TestApplyBSDiff(t *testing.T) {
+	old := []byte("The quick brown fox jumps over the lazy dog")
+	got, err := ApplyBSDiff(old, patch(t, 45, bzBasicCtrl, bzBasicDiff, bzBasicExtra))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "The quick brown cat jumps over the lazy DOG!!"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	got, err = ApplyBSDiff(old, patch(t, 0, bzEmpty, bzEmpty, bzEmpty))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("empty patch: %q, %v", got, err)
+	}
+}
+
+func TestApplyBSDiffCorrupt(t *testing.T) {
+	old := []byte("abcdefgh")
+	valid := patch(t, 45, bzBasicCtrl, bzBasicDiff, bzBasicExtra)
+	withHeader := func(ctrlLen, diffLen, newSize int64) []byte {
+		p := append([]byte(nil), valid...)
+		copy(p[8:32], header(ctrlLen, diffLen, newSize))
+		return p
+	}
+	const maxInt64 = 1<<63 - 1
+
+	tests := []struct {
+		name  string
+		patch []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:31]},
+		{"bad magic", append([]byte("BSDIFF41"), valid[8:]...)},
+		{"negative ctrl length", withHeader(-1, 10, 45)},
+		{"negative diff length", withHeader(10, -1, 45)},
+		{"negative new size", withHeader(10, 10, -1)},
+		{"new size over MaxNewSize", withHeader(10, 10, MaxNewSize+1)},
+		{"ctrl beyond patch", withHeader(int64(len(valid)), 0, 45)},
+		{"diff beyond patch", withHeader(10, int64(len(valid)), 45)},
+		{"lengths overflow", withHeader(maxInt64, maxInt64, 45)},
+		{"control entry x overflows", patch(t, 8, bzCtrlOverflowX, bzZero4, bzEmpty)},
+		{"control entry y overflows", patch(t, 8, bzCtrlOverflowY, bzZero4, bzEmpty)},
+		{"control block ends early", patch(t, 8, bzCtrlShort, bzZero4, bzEmpty)},
+		{"diff block ends early", patch(t, 8, bzCtrlX8, bzZero4, bzEmpty)},
+		{"extra block ends early", patch(t, 45, bzBasicCtrl, bzBasicDiff, bzEmpty)},
+		{"garbage blocks", append(append([]byte(bsdiffMagic), header(4, 4, 8)...), "xxxxxxxxxxxx"...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := ApplyBSDiff(old, tt.patch)
+			if !errors.Is(err, ErrCorruptPatch) {
+				t.Fatalf("got %q, %v; want ErrCorruptPatch", out, err)
+			}
+		})
+	}
+}
+
+func TestOfftin(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 255, 1 << 40, -(1 << 40), 1<<63 - 1} {
+		if got := offtin(offtout(v)); got != v {
+			t.Errorf("offtin(offtout(%d)) = %d", v, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package health provides post-update checks: an HTTP probe, a TCP
+// connect, a command's exit code and a service staying active.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/service"
+)
+
+// Check is one health probe; nil means healthy.
+type Check interface {
+	Check(ctx context.Context) error
+	String() string
+}
+
+// Run retries every check until it passes, waiting interval (default 1s)
+// between attempts, and returns the last error of the first check that is
+// still failing when ctx is done.
+func Run(ctx context.Context, interval time.Duration, checks ...Check) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for _, c := range checks {
+		for {
+			err := c.Check(ctx)
+			if err == nil {
+				break
+			}
+			t := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return fmt.Errorf("health check %s: %w", c, err)
+			case <-t.C:
+			}
+		}
+	}
+	return nil
+}
+
+// HTTP expects Status (default: any 2xx) from a GET of URL.
+type HTTP struct {
+	URL    string
+	Status int
+	Client *http.Client // default: 5s timeout
+}
+
+func (h HTTP) Check(ctx context.Context) error {
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if h.Status != 0 && resp.StatusCode != h.Status {
+		return fmt.Errorf("status %d, want %d", resp.StatusCode, h.Status)
+	}
+	if h.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h HTTP) String() string { return "http " + h.URL }
+
+// TCP expects a connection to Addr (host:port) to succeed.
+type TCP struct {
+	Addr    string
+	Timeout time.Duration // default 5s
+}
+
+func (t TCP) Check(ctx context.Context) error {
+	d := net.Dialer{Timeout: t.Timeout}
+	if d.Timeout == 0 {
+		d.Timeout = 5 * time.Second
+	}
+	conn, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (t TCP) String() string { return "tcp " + t.Addr }
+
+// Command expects Name to exit with status 0.
+type Command struct {
+	Name string
+	Args []string
+	Dir  string
+}
+
+func (c Command) Check(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, truncate(msg, 200))
+		}
+		return err
+	}
+	return nil
+}
+
+func (c Command) String() string {
+	return "command " + strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// ServiceActive expects the service to be running at every poll (every
+// Interval, default 1s) for For. A crash-looping service fails it.
+type ServiceActive struct {
+	Service  service.Controller // must be a service.StatusController
+	For      time.Duration
+	Interval time.Duration
+}
+
+func (s ServiceActive) Check(ctx context.Context) error {
+	sc, ok := s.Service.(service.StatusController)
+	if !ok {
+		return fmt.Errorf("%v cannot report service status", s.Service)
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	end := time.Now().Add(s.For)
+	for {
+		active, err := sc.Active(ctx)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return errors.New("service is not active")
+		}
+		if !time.Now().Before(end) {
+			return nil
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (s ServiceActive) String() string { return fmt.Sprintf("%v active for %s", s.Service, s.For) }
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
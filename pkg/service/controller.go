@@ -9,4 +9,11 @@ type Controller interface {
 	String() string
 }
 
+// StatusController is a Controller that can tell whether the service is
+// running, e.g. for post-update health checks.
+type StatusController interface {
+	Controller
+	Active(ctx context.Context) (bool, error)
+}
+
 type NoopController struct{}
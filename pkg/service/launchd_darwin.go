@@ -4,7 +4,9 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
 type LaunchdController struct {
@@ -25,3 +27,13 @@ func (l LaunchdController) Restart(ctx context.Context) error {
 	return l.Start(ctx)
 }
 func (l LaunchdController) String() string { return "launchd:" + l.Label }
+
+// Active reports whether the job has a PID. A job that is not loaded is
+// an error from launchctl.
+func (l LaunchdController) Active(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "launchctl", "list", l.Label).Output()
+	if err != nil {
+		return false, fmt.Errorf("launchctl list %s: %w", l.Label, err)
+	}
+	return strings.Contains(string(out), `"PID" = `), nil
+}
@@ -5,6 +5,7 @@ package service
 import (
 	"context"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
@@ -25,6 +26,17 @@ func (c NSSMController) Restart(ctx context.Context) error {
 }
 func (c NSSMController) String() string { return "nssm:" + c.ServiceName }
 
+func (c NSSMController) Active(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, c.bin(), "status", c.ServiceName)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	// nssm prints UTF-16 on some versions
+	return strings.Contains(strings.ReplaceAll(string(out), "\x00", ""), "SERVICE_RUNNING"), nil
+}
+
 func (c NSSMController) bin() string {
 	if c.NSSMPath != "" {
 		return c.NSSMPath
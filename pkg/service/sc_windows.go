@@ -5,6 +5,7 @@ package service
 import (
 	"context"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
@@ -21,6 +22,16 @@ func (c SCController) Start(ctx context.Context) error {
 func (c SCController) Restart(ctx context.Context) error { _ = c.Stop(ctx); return c.Start(ctx) }
 func (c SCController) String() string                    { return "sc:" + c.ServiceName }
 
+func (c SCController) Active(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, "sc", "query", c.ServiceName)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(out), "RUNNING"), nil
+}
+
 func runHideSC(ctx context.Context, name string, args ...string) error {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
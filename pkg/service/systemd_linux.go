@@ -5,6 +5,7 @@ package service
 import (
 	"context"
 	"os/exec"
+	"strings"
 )
 
 type SystemdController struct {
@@ -21,3 +22,11 @@ func (s SystemdController) Restart(ctx context.Context) error {
 	return exec.CommandContext(ctx, "systemctl", "restart", s.Unit).Run()
 }
 func (s SystemdController) String() string { return "systemd:" + s.Unit }
+
+func (s SystemdController) Active(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "is-active", s.Unit).Output()
+	if state := strings.TrimSpace(string(out)); state != "" {
+		return state == "active", nil
+	}
+	return false, err
+}
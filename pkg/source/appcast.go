@@ -0,0 +1,209 @@
+package source
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+)
+
+// AppcastSource reads a Sparkle appcast (RSS 2.0) feed.
+//
+// The highest sparkle:version among the items visible to Channels is used;
+// every item with that version contributes its enclosure as an artifact, so
+// one feed can list a macOS app and per-platform agent builds. The platform
+// comes from an enclosure file name like agent_1.2.3_linux_amd64, else from
+// sparkle:os (default macOS, published for amd64 and arm64 as Sparkle apps
+// are universal). sparkle:edSignature becomes the artifact's Ed25519
+// signature; appcasts carry no SHA256, so Config.ArtifactVerifier must be
+// set with the feed's public EdDSA key.
+type AppcastSource struct {
+	Feed RawSource
+
+	// FeedURL resolves relative enclosure URLs; set by NewAppcastSource.
+	FeedURL string
+
+	// Product is the manifest product, checked against Config.Product.
+	// Empty leaves the manifest without one; the feed title is a display
+	// name, not a product id.
+	Product string
+
+	// Channels lists the sparkle:channel values to accept in addition to
+	// items without a channel.
+	Channels []string
+}
+
+func NewAppcastSource(feedURL string) *AppcastSource {
+	return &AppcastSource{Feed: NewHTTPManifestSource(feedURL), FeedURL: feedURL}
+}
+
+type appcast struct {
+	Channel struct {
+		Items []appcastItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type appcastItem struct {
+	PubDate          string           `xml:"pubDate"`
+	Description      string           `xml:"description"`
+	Version          string           `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version"`
+	MinimumSystem    string           `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle minimumSystemVersion"`
+	Channel          string           `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle channel"`
+	ReleaseNotesLink string           `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle releaseNotesLink"`
+	Enclosure        appcastEnclosure `xml:"enclosure"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      string `xml:"length,attr"`
+	Version     string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version,attr"`
+	EdSignature string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle edSignature,attr"`
+	OS          string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle os,attr"`
+}
+
+func (s *AppcastSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if s.Feed == nil {
+		return nil, errors.New("appcast source: Feed is nil")
+	}
+	b, err := s.Feed.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var feed appcast
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		return nil, fmt.Errorf("appcast source: decode feed: %w", err)
+	}
+
+	var items []appcastItem
+	for _, it := range feed.Channel.Items {
+		if it.Version == "" {
+			it.Version = it.Enclosure.Version
+		}
+		if it.Version == "" || it.Enclosure.URL == "" || !s.visible(it.Channel) {
+			continue
+		}
+		items = append(items, it)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("appcast source: no usable items")
+	}
+
+	best := items[0]
+	for _, it := range items[1:] {
+		if updater.CompareVersion(it.Version, best.Version) > 0 {
+			best = it
+		}
+	}
+
+	m := &updater.Manifest{
+		Product: s.Product,
+		Channel: best.Channel,
+		Version: best.Version,
+		Notes:   strings.TrimSpace(best.Description),
+	}
+	if m.Notes == "" {
+		m.Notes = best.ReleaseNotesLink
+	}
+	if t, err := time.Parse(time.RFC1123Z, strings.TrimSpace(best.PubDate)); err == nil {
+		m.PublishedAt = t
+	} else if t, err := time.Parse(time.RFC1123, strings.TrimSpace(best.PubDate)); err == nil {
+		m.PublishedAt = t
+	}
+
+	for _, it := range items {
+		if updater.CompareVersion(it.Version, best.Version) != 0 {
+			continue
+		}
+		arts, err := s.artifacts(it)
+		if err != nil {
+			return nil, err
+		}
+		m.Artifacts = append(m.Artifacts, arts...)
+	}
+	return m, nil
+}
+
+func (s *AppcastSource) visible(channel string) bool {
+	if channel == "" {
+		return true
+	}
+	for _, c := range s.Channels {
+		if strings.EqualFold(c, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AppcastSource) artifacts(it appcastItem) ([]updater.Artifact, error) {
+	enc := it.Enclosure
+	u, err := s.resolve(enc.URL)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(u)
+	if pu, err := url.Parse(u); err == nil {
+		base = path.Base(pu.Path)
+	}
+
+	a := updater.Artifact{
+		Name:         base,
+		URL:          u,
+		Signature:    strings.TrimSpace(enc.EdSignature),
+		MinOSVersion: it.MinimumSystem,
+	}
+	if n, err := strconv.ParseInt(enc.Length, 10, 64); err == nil && n > 0 {
+		a.Size = n
+	}
+
+	if osName, arch, format, ok := platformFromName(base); ok {
+		a.OS, a.Arch, a.Format = osName, arch, format
+		return []updater.Artifact{a}, nil
+	}
+
+	switch strings.ToLower(enc.OS) {
+	case "", "macos", "osx", "darwin":
+		a.OS = "darwin"
+	case "windows", "windows-x64":
+		a.OS, a.Arch = "windows", "amd64"
+	case "windows-x86":
+		a.OS, a.Arch = "windows", "386"
+	case "windows-arm64":
+		a.OS, a.Arch = "windows", "arm64"
+	default:
+		return nil, nil
+	}
+	if strings.HasSuffix(strings.ToLower(base), ".zip") {
+		a.Format = "zip"
+	} else if strings.HasSuffix(strings.ToLower(base), ".tar.gz") {
+		a.Format = "tar.gz"
+	}
+	if a.Arch != "" {
+		return []updater.Artifact{a}, nil
+	}
+	amd, arm := a, a
+	amd.Arch, arm.Arch = "amd64", "arm64"
+	return []updater.Artifact{amd, arm}, nil
+}
+
+func (s *AppcastSource) resolve(ref string) (string, error) {
+	if s.FeedURL == "" || strings.Contains(ref, "://") {
+		return ref, nil
+	}
+	base, err := url.Parse(s.FeedURL)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(r).String(), nil
+}
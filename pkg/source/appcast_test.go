@@ -0,0 +1,88 @@
+package source
+
+import (
+	"context"
+	"testing"
+)
+
+const testAppcast = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:sparkle="http://www.andymatuschak.org/xml-namespaces/sparkle">
+  <channel>
+    <title>Acme Agent Updates</title>
+    <item>
+      <sparkle:version>1.1.0</sparkle:version>
+      <pubDate>Mon, 05 Oct 2026 10:00:00 +0000</pubDate>
+      <enclosure url="Agent-1.1.0.zip" length="100" sparkle:edSignature="c2lnMQ=="/>
+    </item>
+    <item>
+      <sparkle:version>1.2.0</sparkle:version>
+      <sparkle:minimumSystemVersion>12.0</sparkle:minimumSystemVersion>
+      <description> fixes </description>
+      <pubDate>Thu, 15 Oct 2026 10:00:00 +0000</pubDate>
+      <enclosure url="Agent-1.2.0.zip" length="200" sparkle:edSignature="c2lnMg=="/>
+    </item>
+    <item>
+      <sparkle:version>1.2.0</sparkle:version>
+      <enclosure url="https://cdn.example.com/agent_1.2.0_linux_amd64.tar.gz" length="300" sparkle:edSignature="c2lnMw=="/>
+    </item>
+    <item>
+      <sparkle:version>1.2.0</sparkle:version>
+      <enclosure url="agent-setup.exe" sparkle:os="windows-arm64"/>
+    </item>
+    <item>
+      <sparkle:version>1.3.0-beta.1</sparkle:version>
+      <sparkle:channel>beta</sparkle:channel>
+      <enclosure url="Agent-1.3.0-beta.1.zip"/>
+    </item>
+  </channel>
+</rss>`
+
+func TestAppcastSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		channels    []string
+		product     string
+		wantVersion string
+		wantArts    int
+	}{
+		{"default channel", nil, "", "1.2.0", 4}, // zip for darwin amd64+arm64, linux, windows
+		{"product set", nil, "agent", "1.2.0", 4},
+		{"beta", []string{"Beta"}, "", "1.3.0-beta.1", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &AppcastSource{Feed: rawBytes(testAppcast), FeedURL: "https://updates.example.com/mac/appcast.xml", Channels: tt.channels, Product: tt.product}
+			m, err := src.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Product != tt.product {
+				t.Fatalf("product = %q, want %q (the feed title is not a product)", m.Product, tt.product)
+			}
+			if m.Version != tt.wantVersion || len(m.Artifacts) != tt.wantArts {
+				t.Fatalf("got %s with %d artifacts, want %s with %d", m.Version, len(m.Artifacts), tt.wantVersion, tt.wantArts)
+			}
+		})
+	}
+
+	m, err := (&AppcastSource{Feed: rawBytes(testAppcast), FeedURL: "https://updates.example.com/mac/appcast.xml"}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Notes != "fixes" || m.PublishedAt.Day() != 15 {
+		t.Errorf("notes/published = %q %v", m.Notes, m.PublishedAt)
+	}
+	type art struct{ os, arch, format, url, sig, minOS string }
+	want := []art{
+		{"darwin", "amd64", "zip", "https://updates.example.com/mac/Agent-1.2.0.zip", "c2lnMg==", "12.0"},
+		{"darwin", "arm64", "zip", "https://updates.example.com/mac/Agent-1.2.0.zip", "c2lnMg==", "12.0"},
+		{"linux", "amd64", "tar.gz", "https://cdn.example.com/agent_1.2.0_linux_amd64.tar.gz", "c2lnMw==", ""},
+		{"windows", "arm64", "", "https://updates.example.com/mac/agent-setup.exe", "", ""},
+	}
+	for i, w := range want {
+		a := m.Artifacts[i]
+		if got := (art{a.OS, a.Arch, a.Format, a.URL, a.Signature, a.MinOSVersion}); got != w {
+			t.Errorf("artifact %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+)
+
+type CompositePolicy int
+
+const (
+	// FirstSuccess tries sources in order and returns the first manifest.
+	FirstSuccess CompositePolicy = iota
+	// HighestVersion asks all sources and returns the highest version.
+	HighestVersion
+	// Quorum asks all sources and returns a manifest only when enough of
+	// them agree on all of it except download URLs and mirrors.
+	Quorum
+)
+
+// CompositeSource combines several sources, e.g. a primary endpoint, a
+// regional mirror and a local cache directory.
+type CompositeSource struct {
+	Sources []updater.Source
+	Policy  CompositePolicy
+
+	// Quorum is the number of agreeing sources required by the Quorum
+	// policy; default is a majority of Sources.
+	Quorum int
+
+	// Comparer orders versions for HighestVersion; default
+	// updater.LooseVersionComparer.
+	Comparer updater.VersionComparer
+}
+
+func NewCompositeSource(policy CompositePolicy, sources ...updater.Source) *CompositeSource {
+	return &CompositeSource{Sources: sources, Policy: policy}
+}
+
+// CompositeError carries the error of every source that failed.
+type CompositeError struct {
+	Msg    string
+	Errors []error
+}
+
+func (e *CompositeError) Error() string {
+	if len(e.Errors) == 0 {
+		return e.Msg
+	}
+	parts := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		parts[i] = err.Error()
+	}
+	return e.Msg + ": " + strings.Join(parts, "; ")
+}
+
+func (e *CompositeError) Unwrap() []error { return e.Errors }
+
+func (s *CompositeSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if len(s.Sources) == 0 {
+		return nil, errors.New("composite source has no sources")
+	}
+	switch s.Policy {
+	case FirstSuccess:
+		return s.first(ctx)
+	case HighestVersion:
+		return s.highest(ctx)
+	case Quorum:
+		return s.quorum(ctx)
+	}
+	return nil, fmt.Errorf("unknown composite policy %d", s.Policy)
+}
+
+func (s *CompositeSource) first(ctx context.Context) (*updater.Manifest, error) {
+	var errs []error
+	for i, src := range s.Sources {
+		m, err := src.Fetch(ctx)
+		if err == nil {
+			return m, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", sourceName(i, src), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, &CompositeError{Msg: "all sources failed", Errors: errs}
+}
+
+type fetched struct {
+	m   *updater.Manifest
+	err error
+}
+
+// fetchAll queries every source concurrently; results keep source order.
+func (s *CompositeSource) fetchAll(ctx context.Context) ([]fetched, []error) {
+	res := make([]fetched, len(s.Sources))
+	var wg sync.WaitGroup
+	for i, src := range s.Sources {
+		wg.Add(1)
+		go func(i int, src updater.Source) {
+			defer wg.Done()
+			m, err := src.Fetch(ctx)
+			res[i] = fetched{m, err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, r := range res {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sourceName(i, s.Sources[i]), r.err))
+		}
+	}
+	return res, errs
+}
+
+func (s *CompositeSource) highest(ctx context.Context) (*updater.Manifest, error) {
+	cmp := s.Comparer
+	if cmp == nil {
+		cmp = updater.LooseVersionComparer{}
+	}
+	res, errs := s.fetchAll(ctx)
+	var best *updater.Manifest
+	for i, r := range res {
+		if r.err != nil {
+			continue
+		}
+		if best == nil {
+			best = r.m
+			continue
+		}
+		c, err := cmp.Compare(r.m.Version, best.Version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sourceName(i, s.Sources[i]), err))
+			continue
+		}
+		if c > 0 {
+			best = r.m
+		}
+	}
+	if best == nil {
+		return nil, &CompositeError{Msg: "all sources failed", Errors: errs}
+	}
+	return best, nil
+}
+
+func (s *CompositeSource) quorum(ctx context.Context) (*updater.Manifest, error) {
+	need := s.Quorum
+	if need <= 0 {
+		need = len(s.Sources)/2 + 1
+	}
+	res, errs := s.fetchAll(ctx)
+
+	groups := map[string][]int{}
+	var order []string
+	for i, r := range res {
+		if r.err != nil {
+			continue
+		}
+		k, err := agreementKey(r.m)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sourceName(i, s.Sources[i]), err))
+			continue
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+
+	var best []int
+	for _, k := range order {
+		if len(groups[k]) > len(best) {
+			best = groups[k]
+		}
+	}
+	if len(best) >= need {
+		return res[best[0]].m, nil
+	}
+
+	if len(order) > 1 {
+		// sources that answered but disagree
+		for g, k := range order {
+			var names []string
+			for _, i := range groups[k] {
+				names = append(names, sourceName(i, s.Sources[i]))
+			}
+			errs = append(errs, fmt.Errorf("variant %d: version %s from %s", g+1, res[groups[k][0]].m.Version, strings.Join(names, ", ")))
+		}
+	}
+	return nil, &CompositeError{
+		Msg:    fmt.Sprintf("no quorum: %d of %d sources agree, need %d", len(best), len(s.Sources), need),
+		Errors: errs,
+	}
+}
+
+// agreementKey identifies what sources must agree on: the whole manifest
+// (of every release in an index) except artifact and patch download
+// locations, which legitimately differ between a server, its mirrors and a
+// local cache. Artifact and patch order does not matter.
+func agreementKey(m *updater.Manifest) (string, error) {
+	var parts []any
+	for _, a := range m.Artifacts {
+		a.URL, a.Mirrors = "", nil
+		a.SHA256 = normalizeHash(a.SHA256)
+		parts = append(parts, a)
+	}
+	for _, p := range m.Patches {
+		p.URL = ""
+		p.FromSHA256, p.SHA256, p.ToSHA256 = normalizeHash(p.FromSHA256), normalizeHash(p.SHA256), normalizeHash(p.ToSHA256)
+		parts = append(parts, p)
+	}
+	var keys []string
+	for _, p := range parts {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, string(b))
+	}
+	for i := range m.Releases {
+		k, err := agreementKey(&m.Releases[i])
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, "release:"+k)
+	}
+	sort.Strings(keys)
+
+	head := *m
+	head.Artifacts, head.Patches, head.Releases = nil, nil, nil
+	b, err := json.Marshal(head)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(b)
+	for _, k := range keys {
+		h.Write([]byte("\n" + k))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func normalizeHash(h string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(h)), "sha256:")
+}
+
+func sourceName(i int, src updater.Source) string {
+	if st, ok := src.(fmt.Stringer); ok {
+		return st.String()
+	}
+	switch s := src.(type) {
+	case *HTTPManifestSource:
+		return s.ManifestURL
+	case *DirSource:
+		return s.Dir
+	case *GitHubReleaseSource:
+		return "github:" + s.Owner + "/" + s.Repo
+	case *SignedSource:
+		if ms, ok := s.Manifest.(updater.Source); ok {
+			return sourceName(i, ms)
+		}
+	}
+	return fmt.Sprintf("source %d (%T)", i+1, src)
+}
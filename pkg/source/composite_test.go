@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+)
+
+// fixedSource returns m or err and counts its fetches.
+type fixedSource struct {
+	name    string
+	m       *updater.Manifest
+	err     error
+	fetches int
+}
+
+func (s *fixedSource) Fetch(context.Context) (*updater.Manifest, error) {
+	s.fetches++
+	if s.err != nil {
+		return nil, s.err
+	}
+	m := *s.m
+	return &m, nil
+}
+
+func (s *fixedSource) String() string { return s.name }
+
+func serving(name string, m updater.Manifest) *fixedSource { return &fixedSource{name: name, m: &m} }
+
+func failing(name string) *fixedSource {
+	return &fixedSource{name: name, err: errors.New(name + " is down")}
+}
+
+func manifestAt(version string) updater.Manifest {
+	return updater.Manifest{
+		Product:   "agent",
+		Channel:   "stable",
+		Version:   version,
+		ExpiresAt: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		Artifacts: []updater.Artifact{
+			{OS: "linux", Arch: "amd64", URL: "https://primary/agent_linux", SHA256: sumA, Size: 100},
+			{OS: "windows", Arch: "amd64", URL: "https://primary/agent.exe", SHA256: sumB, Size: 200},
+		},
+		Patches: []updater.Patch{{OS: "linux", Arch: "amd64", FromSHA256: sumB, URL: "https://primary/agent.patch", SHA256: sumA, ToSHA256: sumA}},
+	}
+}
+
+func TestCompositeSource(t *testing.T) {
+	mirrored := manifestAt("1.2.0")
+	for i := range mirrored.Artifacts {
+		a := &mirrored.Artifacts[i]
+		a.URL = "file:///var/cache/agent/" + a.OS
+		a.Mirrors = []updater.Mirror{{URL: "https://mirror/" + a.OS}}
+	}
+	mirrored.Patches[0].URL = "https://mirror/agent.patch"
+	mirrored.Artifacts[0], mirrored.Artifacts[1] = mirrored.Artifacts[1], mirrored.Artifacts[0]
+	mirrored.Artifacts[0].SHA256 = "SHA256:" + sumB
+
+	tests := []struct {
+		name    string
+		policy  CompositePolicy
+		quorum  int
+		sources []*fixedSource
+
+		wantVersion string // "" = CompositeError
+		wantFrom    int    // index of the source whose manifest is returned
+		wantErrs    int    // errors in the CompositeError
+		wantFetched []bool // nil = every source
+	}{
+		{name: "first success", policy: FirstSuccess, sources: []*fixedSource{failing("a"), serving("b", manifestAt("1.0.0")), serving("c", manifestAt("2.0.0"))},
+			wantVersion: "1.0.0", wantFrom: 1, wantFetched: []bool{true, true, false}},
+		{name: "first success, all fail", policy: FirstSuccess, sources: []*fixedSource{failing("a"), failing("b")}, wantErrs: 2},
+		{name: "highest version", policy: HighestVersion, sources: []*fixedSource{serving("a", manifestAt("1.9.0")), failing("b"), serving("c", manifestAt("1.10.0")), serving("d", manifestAt("1.2.0"))},
+			wantVersion: "1.10.0", wantFrom: 2},
+		{name: "highest version, all fail", policy: HighestVersion, sources: []*fixedSource{failing("a"), failing("b")}, wantErrs: 2},
+		{name: "quorum, urls and order differ", policy: Quorum, sources: []*fixedSource{serving("a", manifestAt("1.2.0")), serving("b", mirrored)},
+			wantVersion: "1.2.0"},
+		{name: "quorum, majority", policy: Quorum, sources: []*fixedSource{serving("a", manifestAt("1.3.0")), serving("b", manifestAt("1.2.0")), serving("c", manifestAt("1.2.0"))},
+			wantVersion: "1.2.0", wantFrom: 1},
+		{name: "quorum, one source down", policy: Quorum, sources: []*fixedSource{serving("a", manifestAt("1.2.0")), failing("b"), serving("c", manifestAt("1.2.0"))},
+			wantVersion: "1.2.0"},
+		{name: "quorum, too few agree", policy: Quorum, sources: []*fixedSource{serving("a", manifestAt("1.2.0")), failing("b"), serving("c", manifestAt("1.3.0"))},
+			wantErrs: 3}, // b's failure and both variants
+		{name: "explicit quorum", policy: Quorum, quorum: 3, sources: []*fixedSource{serving("a", manifestAt("1.2.0")), serving("b", manifestAt("1.2.0")), failing("c")},
+			wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srcs []updater.Source
+			for _, s := range tt.sources {
+				srcs = append(srcs, s)
+			}
+			c := NewCompositeSource(tt.policy, srcs...)
+			c.Quorum = tt.quorum
+			m, err := c.Fetch(context.Background())
+
+			if tt.wantVersion == "" {
+				var ce *CompositeError
+				if !errors.As(err, &ce) || len(ce.Errors) != tt.wantErrs {
+					t.Fatalf("got %v, %v; want a CompositeError with %d errors", m, err, tt.wantErrs)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if m.Version != tt.wantVersion || m.Artifacts[0].URL != tt.sources[tt.wantFrom].m.Artifacts[0].URL {
+					t.Fatalf("got %s from %s, want %s from %s", m.Version, m.Artifacts[0].URL, tt.wantVersion, tt.sources[tt.wantFrom].name)
+				}
+			}
+			for i, s := range tt.sources {
+				want := tt.wantFetched == nil || tt.wantFetched[i]
+				if (s.fetches == 1) != want || s.fetches > 1 {
+					t.Errorf("%s fetched %d times, want fetched=%v", s.name, s.fetches, want)
+				}
+			}
+		})
+	}
+
+	// errors.Is sees the failure of each source
+	down := errors.New("connection refused")
+	_, err := NewCompositeSource(FirstSuccess, &fixedSource{name: "a", err: down}).Fetch(context.Background())
+	if !errors.Is(err, down) {
+		t.Fatalf("err = %v, want it to wrap the source error", err)
+	}
+}
+
+func TestQuorumAgreement(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *updater.Manifest)
+		agree  bool
+	}{
+		{"identical", func(m *updater.Manifest) {}, true},
+		{"artifact url", func(m *updater.Manifest) { m.Artifacts[0].URL = "https://elsewhere/agent" }, true},
+		{"mirrors", func(m *updater.Manifest) { m.Artifacts[0].Mirrors = []updater.Mirror{{URL: "https://mirror/agent"}} }, true},
+		{"patch url", func(m *updater.Manifest) { m.Patches[0].URL = "https://elsewhere/agent.patch" }, true},
+		{"hash spelling", func(m *updater.Manifest) { m.Artifacts[0].SHA256 = "sha256:" + sumA }, true},
+		{"version", func(m *updater.Manifest) { m.Version = "1.2.1" }, false},
+		{"product", func(m *updater.Manifest) { m.Product = "other" }, false},
+		{"channel", func(m *updater.Manifest) { m.Channel = "beta" }, false},
+		{"expires_at", func(m *updater.Manifest) { m.ExpiresAt = m.ExpiresAt.AddDate(1, 0, 0) }, false},
+		{"key_id", func(m *updater.Manifest) { m.KeyID = "k2" }, false},
+		{"rollout", func(m *updater.Manifest) { m.Rollout = &updater.Rollout{Percentage: 100} }, false},
+		{"artifact sha256", func(m *updater.Manifest) { m.Artifacts[0].SHA256 = sumB }, false},
+		{"artifact size", func(m *updater.Manifest) { m.Artifacts[0].Size++ }, false},
+		{"artifact format", func(m *updater.Manifest) { m.Artifacts[0].Format = "zip" }, false},
+		{"artifact path", func(m *updater.Manifest) { m.Artifacts[0].Path = "bin/evil" }, false},
+		{"artifact signature_url", func(m *updater.Manifest) { m.Artifacts[0].SignatureURL = "https://elsewhere/agent.sig" }, false},
+		{"artifact min_os_version", func(m *updater.Manifest) { m.Artifacts[0].MinOSVersion = "99" }, false},
+		{"extra artifact", func(m *updater.Manifest) {
+			m.Artifacts = append(m.Artifacts, updater.Artifact{OS: "darwin", Arch: "arm64", SHA256: sumA})
+		}, false},
+		{"patch to_sha256", func(m *updater.Manifest) { m.Patches[0].ToSHA256 = sumB }, false},
+		{"patch from_version", func(m *updater.Manifest) { m.Patches[0].FromVersion = "1.0.0" }, false},
+		{"release in index", func(m *updater.Manifest) { m.Releases = []updater.Manifest{manifestAt("1.1.0")} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := manifestAt("1.2.0"), manifestAt("1.2.0")
+			tt.change(&b)
+			ka, err := agreementKey(&a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kb, err := agreementKey(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ka == kb) != tt.agree {
+				t.Fatalf("agree = %v, want %v", ka == kb, tt.agree)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+)
+
+func decodeManifest(b []byte) (*updater.Manifest, error) {
+	var m updater.Manifest
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,152 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// DirSource reads a manifest from a local directory (USB stick, network
+// share, unpacked bundle). Artifact, mirror, patch and signature URLs may be
+// file:// URLs or paths relative to Dir; both are rewritten to absolute
+// file:// URLs, which the updater copies instead of downloading.
+type DirSource struct {
+	Dir          string
+	ManifestName string // default manifest.json
+}
+
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{Dir: dir, ManifestName: "manifest.json"}
+}
+
+// NewSignedDirSource expects the detached signature at <manifest>.sig.
+func NewSignedDirSource(dir string, v verify.SignatureVerifier) *SignedSource {
+	d := NewDirSource(dir)
+	return &SignedSource{
+		Manifest:  d,
+		Signature: d.File(d.ManifestName + ".sig"),
+		Verifier:  v,
+	}
+}
+
+func (s *DirSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	b, err := s.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := decodeManifest(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolveURLs(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *DirSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	name := s.ManifestName
+	if name == "" {
+		name = "manifest.json"
+	}
+	return s.File(name).FetchRaw(ctx)
+}
+
+// File returns a RawSource for another file in Dir, e.g. a signature or a
+// key rotation document.
+func (s *DirSource) File(name string) RawSource {
+	return dirFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+}
+
+type dirFile string
+
+func (f dirFile) FetchRaw(ctx context.Context) ([]byte, error) {
+	fh, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	b, err := io.ReadAll(io.LimitReader(fh, maxManifestBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxManifestBytes {
+		return nil, fmt.Errorf("%s too large (> %d bytes)", f, maxManifestBytes)
+	}
+	return b, nil
+}
+
+// urlResolver is implemented by sources whose manifests may contain
+// relative URLs; SignedSource resolves them after verification.
+type urlResolver interface {
+	resolveURLs(m *updater.Manifest) error
+}
+
+func (s *DirSource) resolveURLs(m *updater.Manifest) error {
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return err
+	}
+	resolve := func(u *string) error {
+		if *u == "" {
+			return nil
+		}
+		r, err := resolveLocalURL(dir, *u)
+		if err != nil {
+			return err
+		}
+		*u = r
+		return nil
+	}
+	for i := range m.Artifacts {
+		a := &m.Artifacts[i]
+		if err := resolve(&a.URL); err != nil {
+			return err
+		}
+		if err := resolve(&a.SignatureURL); err != nil {
+			return err
+		}
+		for j := range a.Mirrors {
+			if err := resolve(&a.Mirrors[j].URL); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range m.Patches {
+		if err := resolve(&m.Patches[i].URL); err != nil {
+			return err
+		}
+	}
+	for i := range m.Releases {
+		if err := s.resolveURLs(&m.Releases[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLocalURL leaves http(s) and file URLs alone and turns absolute or
+// dir-relative paths into file:// URLs. Relative paths may not leave dir.
+func resolveLocalURL(dir, raw string) (string, error) {
+	if _, ok := util.LocalPath(raw); ok {
+		return raw, nil
+	}
+	if i := strings.Index(raw, "://"); i > 0 {
+		return raw, nil
+	}
+	p := filepath.FromSlash(raw)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+		if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("path %q is outside %s", raw, dir)
+		}
+	}
+	return util.FileURL(p), nil
+}
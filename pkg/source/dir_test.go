@@ -0,0 +1,84 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+func TestDirSourceURLs(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(t.TempDir(), "agent")
+
+	tests := []struct {
+		name string
+		url  string
+		want string // "" = rejected
+	}{
+		{"relative", "bin/agent", util.FileURL(filepath.Join(dir, "bin", "agent"))},
+		{"dot relative", "./bin/../agent", util.FileURL(filepath.Join(dir, "agent"))},
+		{"absolute path", abs, util.FileURL(abs)},
+		{"file url", util.FileURL(abs), util.FileURL(abs)},
+		{"https", "https://cdn.example.com/agent", "https://cdn.example.com/agent"},
+		{"parent", "../agent", ""},
+		{"parent after subdir", "bin/../../agent", ""},
+		{"parent only", "..", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := json.Marshal(tt.url)
+			manifest := `{"product":"agent","version":"1.2.0","artifacts":[{"os":"linux","arch":"amd64","url":` + string(u) + `,"sha256":"` + sumA + `"}]}`
+			if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := NewDirSource(dir).Fetch(context.Background())
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "outside") {
+					t.Fatalf("got %+v, %v; want the path rejected", m, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Artifacts[0].URL; got != tt.want {
+				t.Fatalf("url = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirSourceResolvesEveryURL(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"product":"agent","releases":[{"version":"1.2.0","artifacts":[{"os":"linux","arch":"amd64",
+		"url":"a","signature_url":"a.sig","mirrors":[{"url":"m/a"}]}],
+		"patches":[{"os":"linux","arch":"amd64","from_sha256":"` + sumA + `","url":"p/a.bsdiff"}]}]}`
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewDirSource(dir).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := m.Releases[0]
+	for _, u := range []string{r.Artifacts[0].URL, r.Artifacts[0].SignatureURL, r.Artifacts[0].Mirrors[0].URL, r.Patches[0].URL} {
+		p, ok := util.LocalPath(u)
+		if !ok || !strings.HasPrefix(p, dir) {
+			t.Errorf("url %s not resolved into %s", u, dir)
+		}
+	}
+
+	// a traversal anywhere fails the whole manifest
+	bad := strings.Replace(manifest, `"p/a.bsdiff"`, `"../p/a.bsdiff"`, 1)
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDirSource(dir).Fetch(context.Background()); err == nil {
+		t.Fatal("patch url outside the directory accepted")
+	}
+}
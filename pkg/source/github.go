@@ -0,0 +1,380 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+// GitHubReleaseSource builds a manifest from GitHub (or GitHub Enterprise /
+// any compatible API) release assets named like
+// <product>_<version>_<os>_<arch>[.exe|.tar.gz|.tgz|.zip], with hashes taken
+// from a checksums.txt asset ("<sha256>  <file>" per line). An asset with a
+// matching <asset>.sig is given that as its signature_url.
+type GitHubReleaseSource struct {
+	BaseURL string // default https://api.github.com; GHE: https://host/api/v3
+	Owner   string
+	Repo    string
+	// Token authenticates the API calls (release list and checksums) for
+	// private repos and higher rate limits. Artifacts are downloaded from
+	// browser_download_url without it, so a private repo also needs a
+	// Config.HTTPClient that authorizes those downloads.
+	Token string
+
+	Product string // asset name prefix; default Repo
+
+	// Channel "" or "stable" uses releases only; "prerelease" also takes
+	// prereleases; any other value also takes prereleases tagged with it
+	// (e.g. "beta" matches v1.3.0-beta.2).
+	Channel string
+
+	// PrereleaseChannel labels prereleases taken by Channel "prerelease";
+	// default beta, so the updater's channel order accepts them.
+	PrereleaseChannel string
+
+	ChecksumsAsset string // default checksums.txt
+
+	Timeout   time.Duration
+	UserAgent string
+	Retry     *util.RetryPolicy
+}
+
+func NewGitHubReleaseSource(owner, repo string) *GitHubReleaseSource {
+	return &GitHubReleaseSource{
+		BaseURL:        "https://api.github.com",
+		Owner:          owner,
+		Repo:           repo,
+		ChecksumsAsset: "checksums.txt",
+		Timeout:        30 * time.Second,
+		UserAgent:      "portable-updater/1.0",
+		Retry:          util.DefaultRetryPolicy(),
+	}
+}
+
+type ghRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []ghAsset `json:"assets"`
+}
+
+type ghAsset struct {
+	Name               string `json:"name"`
+	URL                string `json:"url"` // API url; octet-stream with Accept header
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
+}
+
+func (s *GitHubReleaseSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if s.Owner == "" || s.Repo == "" {
+		return nil, errors.New("github source: Owner and Repo are required")
+	}
+	base := strings.TrimRight(s.BaseURL, "/")
+	if base == "" {
+		base = "https://api.github.com"
+	}
+
+	releases, err := s.listReleases(ctx, fmt.Sprintf("%s/repos/%s/%s/releases", base, url.PathEscape(s.Owner), url.PathEscape(s.Repo)))
+	if err != nil {
+		return nil, err
+	}
+
+	rel := s.pickRelease(releases)
+	if rel == nil {
+		return nil, fmt.Errorf("github source: no release for channel %q", s.channel())
+	}
+	return s.toManifest(ctx, rel)
+}
+
+// maxReleasePages bounds how far back the release list is paged.
+const maxReleasePages = 10
+
+// listReleases returns the candidates for Channel: the repo's latest
+// release for stable, else every release, following Link pagination.
+func (s *GitHubReleaseSource) listReleases(ctx context.Context, releasesURL string) ([]ghRelease, error) {
+	if strings.EqualFold(s.channel(), "stable") {
+		b, _, err := s.fetch(ctx, releasesURL+"/latest", "application/vnd.github+json")
+		var se *util.HTTPStatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rel ghRelease
+		if err := json.Unmarshal(b, &rel); err != nil {
+			return nil, fmt.Errorf("github source: decode latest release: %w", err)
+		}
+		return []ghRelease{rel}, nil
+	}
+
+	var all []ghRelease
+	next := releasesURL + "?per_page=100"
+	for page := 0; next != "" && page < maxReleasePages; page++ {
+		b, h, err := s.fetch(ctx, next, "application/vnd.github+json")
+		if err != nil {
+			return nil, err
+		}
+		var releases []ghRelease
+		if err := json.Unmarshal(b, &releases); err != nil {
+			return nil, fmt.Errorf("github source: decode releases: %w", err)
+		}
+		all = append(all, releases...)
+		if next, err = nextPage(releasesURL, h.Get("Link")); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// nextPage returns the rel="next" URL of a Link header, which must stay on
+// the API host the token is meant for.
+func nextPage(releasesURL, link string) (string, error) {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return "", fmt.Errorf("github source: bad Link header: %w", err)
+		}
+		base, err := url.Parse(releasesURL)
+		if err != nil {
+			return "", err
+		}
+		if next.Scheme != base.Scheme || next.Host != base.Host {
+			return "", fmt.Errorf("github source: next page %s is not on %s", next.Redacted(), base.Host)
+		}
+		return next.String(), nil
+	}
+	return "", nil
+}
+
+func (s *GitHubReleaseSource) channel() string {
+	if s.Channel == "" {
+		return "stable"
+	}
+	return s.Channel
+}
+
+// releaseChannel is the manifest channel of rel.
+func (s *GitHubReleaseSource) releaseChannel(rel *ghRelease) string {
+	ch := s.channel()
+	switch {
+	case !rel.Prerelease:
+		return "stable"
+	case !strings.EqualFold(ch, "prerelease"):
+		return ch
+	case s.PrereleaseChannel != "":
+		return s.PrereleaseChannel
+	}
+	return "beta"
+}
+
+// pickRelease returns the highest version allowed by Channel.
+func (s *GitHubReleaseSource) pickRelease(releases []ghRelease) *ghRelease {
+	ch := strings.ToLower(s.channel())
+	var best *ghRelease
+	for i := range releases {
+		r := &releases[i]
+		if r.Draft {
+			continue
+		}
+		if r.Prerelease {
+			switch ch {
+			case "stable":
+				continue
+			case "prerelease":
+			default:
+				if !strings.Contains(strings.ToLower(r.TagName), "-"+ch) {
+					continue
+				}
+			}
+		}
+		if best == nil || updater.CompareVersion(r.TagName, best.TagName) > 0 {
+			best = r
+		}
+	}
+	return best
+}
+
+func (s *GitHubReleaseSource) toManifest(ctx context.Context, rel *ghRelease) (*updater.Manifest, error) {
+	product := s.Product
+	if product == "" {
+		product = s.Repo
+	}
+	checksumsName := s.ChecksumsAsset
+	if checksumsName == "" {
+		checksumsName = "checksums.txt"
+	}
+
+	byName := map[string]ghAsset{}
+	for _, a := range rel.Assets {
+		byName[a.Name] = a
+	}
+	ca, ok := byName[checksumsName]
+	if !ok {
+		return nil, fmt.Errorf("github source: release %s has no %s asset", rel.TagName, checksumsName)
+	}
+	b, err := s.downloadAsset(ctx, ca)
+	if err != nil {
+		return nil, fmt.Errorf("github source: %s: %w", checksumsName, err)
+	}
+	sums := parseChecksums(b)
+
+	m := &updater.Manifest{
+		Product:     product,
+		Channel:     s.releaseChannel(rel),
+		Version:     strings.TrimPrefix(rel.TagName, "v"),
+		PublishedAt: rel.PublishedAt,
+		Notes:       rel.Body,
+	}
+	for _, a := range rel.Assets {
+		osName, arch, format, ok := parseAssetName(a.Name, product)
+		if !ok {
+			continue
+		}
+		sum, ok := sums[a.Name]
+		if !ok {
+			continue
+		}
+		art := updater.Artifact{
+			OS:     osName,
+			Arch:   arch,
+			Name:   a.Name,
+			URL:    a.BrowserDownloadURL,
+			SHA256: sum,
+			Size:   a.Size,
+			Format: format,
+		}
+		if sig, ok := byName[a.Name+".sig"]; ok {
+			art.SignatureURL = sig.BrowserDownloadURL
+		}
+		m.Artifacts = append(m.Artifacts, art)
+	}
+	if len(m.Artifacts) == 0 {
+		return nil, fmt.Errorf("github source: release %s has no %s_<os>_<arch> assets listed in %s", rel.TagName, product, checksumsName)
+	}
+	return m, nil
+}
+
+// parseAssetName splits "<product>_<version>_<os>_<arch><ext>".
+func parseAssetName(name, product string) (osName, arch, format string, ok bool) {
+	if !strings.HasPrefix(name, product+"_") || strings.Count(name, "_") < 3 {
+		return "", "", "", false
+	}
+	return platformFromName(name)
+}
+
+// platformFromName reads os and arch from the last two "_" separated parts
+// of a file name, after an optional .exe or archive extension.
+func platformFromName(name string) (osName, arch, format string, ok bool) {
+	base := name
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".exe"} {
+		if strings.HasSuffix(base, ext) {
+			base = strings.TrimSuffix(base, ext)
+			if ext != ".exe" {
+				format, _ = util.NormalizeArchiveFormat(ext)
+			}
+			break
+		}
+	}
+	parts := strings.Split(base, "_")
+	if len(parts) < 3 {
+		return "", "", "", false
+	}
+	osName, arch = parts[len(parts)-2], parts[len(parts)-1]
+	if strings.Contains(arch, ".") {
+		// *.sig, *.sbom.json, ...
+		return "", "", "", false
+	}
+	switch osName {
+	case "windows", "linux", "darwin", "freebsd", "openbsd", "netbsd":
+	default:
+		return "", "", "", false
+	}
+	return osName, arch, format, true
+}
+
+func parseChecksums(b []byte) map[string]string {
+	out := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) != 2 {
+			continue
+		}
+		out[strings.TrimPrefix(f[1], "*")] = strings.ToLower(f[0])
+	}
+	return out
+}
+
+func (s *GitHubReleaseSource) downloadAsset(ctx context.Context, a ghAsset) ([]byte, error) {
+	if s.Token != "" && a.URL != "" {
+		// the API url works for private repos too
+		return s.get(ctx, a.URL, "application/octet-stream")
+	}
+	return s.get(ctx, a.BrowserDownloadURL, "")
+}
+
+func (s *GitHubReleaseSource) get(ctx context.Context, u, accept string) ([]byte, error) {
+	b, _, err := s.fetch(ctx, u, accept)
+	return b, err
+}
+
+func (s *GitHubReleaseSource) fetch(ctx context.Context, u, accept string) ([]byte, http.Header, error) {
+	ua := s.UserAgent
+	if ua == "" {
+		ua = "portable-updater/1.0"
+	}
+	var out []byte
+	var header http.Header
+	err := s.Retry.Do(ctx, func(int) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return util.Permanent(err)
+		}
+		req.Header.Set("User-Agent", ua)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		}
+
+		client := &http.Client{Timeout: s.Timeout}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return util.NewHTTPStatusError("github", resp)
+		}
+		out, err = io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(out) > maxManifestBytes {
+			return util.Permanent(fmt.Errorf("github: %s: response too large (> %d bytes)", u, maxManifestBytes))
+		}
+		header = resp.Header
+		return nil
+	})
+	return out, header, err
+}
@@ -0,0 +1,382 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+)
+
+const (
+	sumA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	sumB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// ghServer fakes the releases API, with releases in creation order. The list
+// is served perPage at a time (default all) with Link headers, and latest is
+// the last stable release. Assets are served from /download/<tag>/<name> and,
+// for the API url, /api/<tag>/<name>; the body of every asset is
+// checksums[tag].
+type ghServer struct {
+	*httptest.Server
+	releases  []ghRelease
+	checksums map[string]string
+	perPage   int
+	auth      map[string]string // request path -> Authorization header
+	accept    map[string]string // request path -> Accept header
+	userAgent map[string]string // request path -> User-Agent header
+	pages     []string          // release list pages requested
+}
+
+func newGHServer(t *testing.T, checksums map[string]string, releases ...ghRelease) *ghServer {
+	t.Helper()
+	s := &ghServer{releases: releases, checksums: checksums, auth: map[string]string{}, accept: map[string]string{}, userAgent: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.auth[r.URL.Path] = r.Header.Get("Authorization")
+		s.accept[r.URL.Path] = r.Header.Get("Accept")
+		s.userAgent[r.URL.Path] = r.Header.Get("User-Agent")
+		switch r.URL.Path {
+		case "/repos/acme/agent/releases/latest":
+			for i := len(s.releases) - 1; i >= 0; i-- {
+				if rel := s.releases[i]; !rel.Draft && !rel.Prerelease {
+					_ = json.NewEncoder(w).Encode(rel)
+					return
+				}
+			}
+			http.NotFound(w, r)
+			return
+		case "/repos/acme/agent/releases":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			page = max(page, 1)
+			s.pages = append(s.pages, strconv.Itoa(page))
+			n := s.perPage
+			if n == 0 {
+				n = len(s.releases)
+			}
+			// newest first, like the API
+			var list []ghRelease
+			for i := len(s.releases) - 1; i >= 0; i-- {
+				list = append(list, s.releases[i])
+			}
+			from, to := min((page-1)*n, len(list)), min(page*n, len(list))
+			if to < len(list) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=%d&page=%d>; rel="next", <%s%s?page=99>; rel="last"`, s.URL, r.URL.Path, n, page+1, s.URL, r.URL.Path))
+			}
+			_ = json.NewEncoder(w).Encode(list[from:to])
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || (parts[0] != "download" && parts[0] != "api") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(s.checksums[parts[1]]))
+	}))
+	t.Cleanup(s.Close)
+	for i := range s.releases {
+		tag := s.releases[i].TagName
+		for j := range s.releases[i].Assets {
+			a := &s.releases[i].Assets[j]
+			a.URL = s.URL + "/api/" + tag + "/" + a.Name
+			a.BrowserDownloadURL = s.URL + "/download/" + tag + "/" + a.Name
+		}
+	}
+	return s
+}
+
+func (s *ghServer) source() *GitHubReleaseSource {
+	src := NewGitHubReleaseSource("acme", "agent")
+	src.BaseURL = s.URL
+	src.Retry = nil
+	return src
+}
+
+func release(tag string, pre bool, assets ...string) ghRelease {
+	r := ghRelease{TagName: tag, Prerelease: pre}
+	for _, a := range assets {
+		r.Assets = append(r.Assets, ghAsset{Name: a, Size: 10})
+	}
+	return r
+}
+
+// binRelease is a release with a checksums.txt and one linux/amd64 binary.
+func binRelease(tag string, pre bool) (ghRelease, string) {
+	bin := "agent_" + strings.TrimPrefix(tag, "v") + "_linux_amd64"
+	return release(tag, pre, "checksums.txt", bin), sumA + "  " + bin + "\n"
+}
+
+func TestGitHubReleaseSelection(t *testing.T) {
+	checksums := map[string]string{}
+	var releases []ghRelease
+	for _, r := range []struct {
+		tag        string
+		prerelease bool
+	}{
+		{"v1.0.0", false},
+		{"v1.1.0", false},
+		{"v1.2.0-beta.1", true},
+		{"v1.3.0-nightly.7", true},
+		{"v1.10.0-rc.1", true},
+		{"v9.0.0", false}, // draft
+	} {
+		rel, sums := binRelease(r.tag, r.prerelease)
+		rel.Draft = r.tag == "v9.0.0"
+		releases = append(releases, rel)
+		checksums[r.tag] = sums
+	}
+
+	tests := []struct {
+		channel, prereleaseChannel string
+		wantVersion, wantChannel   string
+	}{
+		{"", "", "1.1.0", "stable"},
+		{"stable", "", "1.1.0", "stable"},
+		{"beta", "", "1.2.0-beta.1", "beta"},
+		{"nightly", "", "1.3.0-nightly.7", "nightly"},
+		{"prerelease", "", "1.10.0-rc.1", "beta"},
+		{"prerelease", "edge", "1.10.0-rc.1", "edge"},
+		{"alpha", "", "1.1.0", "stable"}, // no alpha prereleases: newest stable
+	}
+	srv := newGHServer(t, checksums, releases...)
+	for _, tt := range tests {
+		t.Run(tt.channel+"/"+tt.prereleaseChannel, func(t *testing.T) {
+			src := srv.source()
+			src.Channel = tt.channel
+			src.PrereleaseChannel = tt.prereleaseChannel
+			m, err := src.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Product != "agent" || m.Version != tt.wantVersion || m.Channel != tt.wantChannel {
+				t.Fatalf("got %s %s %s, want agent %s %s", m.Product, m.Version, m.Channel, tt.wantVersion, tt.wantChannel)
+			}
+		})
+	}
+}
+
+func TestGitHubPrereleaseAcceptedByUpdater(t *testing.T) {
+	// a "prerelease" source must produce a manifest a beta client accepts
+	rel, sums := binRelease("v2.0.0-rc.1", true)
+	srv := newGHServer(t, map[string]string{rel.TagName: sums}, rel)
+	src := srv.source()
+	src.Channel = "prerelease"
+	m, err := src.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ch := range updater.DefaultChannelOrder {
+		found = found || ch == m.Channel
+	}
+	if !found {
+		t.Fatalf("channel %q is not in %v", m.Channel, updater.DefaultChannelOrder)
+	}
+}
+
+func TestGitHubChecksums(t *testing.T) {
+	rel := release("v1.2.0", false,
+		"checksums.txt",
+		"agent_1.2.0_linux_amd64",
+		"agent_1.2.0_linux_arm64.tar.gz",
+		"agent_1.2.0_linux_arm64.tar.gz.sig",
+		"agent_1.2.0_windows_amd64.exe",
+		"agent_1.2.0_darwin_arm64.zip", // not in checksums.txt
+		"agent_1.2.0_linux_amd64.sbom.json",
+		"other_1.2.0_linux_amd64",
+	)
+	sums := strings.Join([]string{
+		sumA + "  agent_1.2.0_linux_amd64",
+		strings.ToUpper(sumB) + " *agent_1.2.0_linux_arm64.tar.gz", // binary mode, upper case
+		sumA + "  agent_1.2.0_windows_amd64.exe",
+		sumA + "  agent_1.2.0_linux_amd64.sbom.json",
+		sumA + "  other_1.2.0_linux_amd64",
+		"not a checksum line at all",
+		"",
+	}, "\r\n")
+	srv := newGHServer(t, map[string]string{"v1.2.0": sums}, rel)
+	m, err := srv.source().Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type art struct{ name, os, arch, format, sum, sig string }
+	want := []art{
+		{"agent_1.2.0_linux_amd64", "linux", "amd64", "", sumA, ""},
+		{"agent_1.2.0_linux_arm64.tar.gz", "linux", "arm64", "tar.gz", sumB, "agent_1.2.0_linux_arm64.tar.gz.sig"},
+		{"agent_1.2.0_windows_amd64.exe", "windows", "amd64", "", sumA, ""},
+	}
+	if len(m.Artifacts) != len(want) {
+		t.Fatalf("got %d artifacts, want %d: %+v", len(m.Artifacts), len(want), m.Artifacts)
+	}
+	for i, w := range want {
+		a := m.Artifacts[i]
+		sig := ""
+		if a.SignatureURL != "" {
+			sig = path.Base(a.SignatureURL)
+		}
+		got := art{a.Name, a.OS, a.Arch, a.Format, a.SHA256, sig}
+		if got != w {
+			t.Errorf("artifact %d = %+v, want %+v", i, got, w)
+		}
+		if a.URL != srv.URL+"/download/v1.2.0/"+w.name || a.Size != 10 {
+			t.Errorf("artifact %d url/size = %s/%d", i, a.URL, a.Size)
+		}
+	}
+}
+
+func TestGitHubErrors(t *testing.T) {
+	noSums := release("v1.0.0", false, "agent_1.0.0_linux_amd64")
+	beta := release("v1.0.0", true, "checksums.txt", "agent_1.0.0_linux_amd64")
+	unlisted := release("v1.0.0", false, "checksums.txt", "agent_1.0.0_linux_amd64")
+	tests := []struct {
+		name      string
+		rel       ghRelease
+		checksums string
+		channel   string
+		want      string
+	}{
+		{"no release for channel", beta, "", "stable", "no release"},
+		{"no checksums asset", noSums, "", "", "no checksums.txt asset"},
+		{"no listed assets", unlisted, sumA + "  agent_1.0.0_linux_arm64\n", "", "no agent_<os>_<arch> assets"},
+		{"checksums too large", unlisted, strings.Repeat("x", maxManifestBytes+1), "", "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newGHServer(t, map[string]string{"v1.0.0": tt.checksums}, tt.rel)
+			src := srv.source()
+			src.Channel = tt.channel
+			_, err := src.Fetch(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubToken(t *testing.T) {
+	rel, sums := binRelease("v1.0.0", false)
+	for _, token := range []string{"", "s3cret"} {
+		srv := newGHServer(t, map[string]string{"v1.0.0": sums}, rel)
+		src := srv.source()
+		src.Token = token
+		m, err := src.Fetch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantAuth := ""
+		checksumsPath := "/download/v1.0.0/checksums.txt"
+		if token != "" {
+			// private repos: checksums come from the API url
+			wantAuth = "Bearer " + token
+			checksumsPath = "/api/v1.0.0/checksums.txt"
+			if got := srv.accept[checksumsPath]; got != "application/octet-stream" {
+				t.Errorf("checksums Accept = %q", got)
+			}
+		}
+		for _, p := range []string{"/repos/acme/agent/releases/latest", checksumsPath} {
+			got, ok := srv.auth[p]
+			if !ok {
+				t.Fatalf("token %q: %s not requested", token, p)
+			}
+			if got != wantAuth {
+				t.Errorf("token %q: %s Authorization = %q, want %q", token, p, got, wantAuth)
+			}
+		}
+		// artifacts are downloaded by the updater, not by the source
+		if !strings.HasPrefix(m.Artifacts[0].URL, srv.URL+"/download/") {
+			t.Errorf("artifact url = %s", m.Artifacts[0].URL)
+		}
+	}
+}
+
+func TestGitHubPagination(t *testing.T) {
+	checksums := map[string]string{}
+	var releases []ghRelease
+	// creation order; the highest beta is the oldest, on the last page
+	for _, r := range []struct {
+		tag        string
+		prerelease bool
+	}{
+		{"v2.0.0-beta.3", true},
+		{"v1.0.0", false},
+		{"v1.1.0", false},
+		{"v1.2.0-beta.1", true},
+		{"v1.2.0", false},
+	} {
+		rel, sums := binRelease(r.tag, r.prerelease)
+		releases = append(releases, rel)
+		checksums[r.tag] = sums
+	}
+
+	tests := []struct {
+		channel     string
+		wantVersion string
+		wantPages   []string
+	}{
+		{"", "1.2.0", nil}, // releases/latest only
+		{"beta", "2.0.0-beta.3", []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			srv := newGHServer(t, checksums, releases...)
+			srv.perPage = 2
+			src := srv.source()
+			src.Channel = tt.channel
+			m, err := src.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Version != tt.wantVersion {
+				t.Errorf("version = %s, want %s", m.Version, tt.wantVersion)
+			}
+			if strings.Join(srv.pages, ",") != strings.Join(tt.wantPages, ",") {
+				t.Errorf("pages requested = %v, want %v", srv.pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestGitHubNextPage(t *testing.T) {
+	const list = "https://api.github.com/repos/acme/agent/releases"
+	tests := []struct {
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{`<` + list + `?page=2>; rel="next", <` + list + `?page=5>; rel="last"`, list + "?page=2", false},
+		{`<` + list + `?page=1>; rel="prev", <` + list + `?page=1>; rel="first"`, "", false},
+		{`<` + list + `?page=3>;rel="next"`, list + "?page=3", false},
+		{`<https://evil.example.com/releases?page=2>; rel="next"`, "", true},
+		{`<http://api.github.com/repos/acme/agent/releases?page=2>; rel="next"`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := nextPage(list, tt.link)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("nextPage(%q) = %q, %v; want %q, error=%v", tt.link, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGitHubDefaultUserAgent(t *testing.T) {
+	rel, sums := binRelease("v1.0.0", false)
+	srv := newGHServer(t, map[string]string{"v1.0.0": sums}, rel)
+	// a literal, not NewGitHubReleaseSource
+	src := &GitHubReleaseSource{BaseURL: srv.URL, Owner: "acme", Repo: "agent"}
+	if _, err := src.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/repos/acme/agent/releases/latest", "/download/v1.0.0/checksums.txt"} {
+		if got := srv.userAgent[p]; got != "portable-updater/1.0" {
+			t.Errorf("%s User-Agent = %q", p, got)
+		}
+	}
+}
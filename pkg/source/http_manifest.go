@@ -2,18 +2,24 @@ package source
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
 )
 
+const maxManifestBytes = 8 << 20
+
 type HTTPManifestSource struct {
 	ManifestURL string
 	Timeout     time.Duration
 	UserAgent   string
+
+	// Retry applies to each fetch; nil means a single attempt.
+	Retry *util.RetryPolicy
 }
 
 func NewHTTPManifestSource(url string) *HTTPManifestSource {
@@ -21,14 +27,35 @@ func NewHTTPManifestSource(url string) *HTTPManifestSource {
 		ManifestURL: url,
 		Timeout:     30 * time.Second,
 		UserAgent:   "portable-updater/1.0",
+		Retry:       util.DefaultRetryPolicy(),
 	}
 }
 
 func (s *HTTPManifestSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	b, err := s.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decodeManifest(b)
+}
+
+// FetchRaw returns the manifest body exactly as served, so it can be
+// signature-checked before decoding.
+func (s *HTTPManifestSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	var b []byte
+	err := s.Retry.Do(ctx, func(int) error {
+		var err error
+		b, err = s.fetchOnce(ctx)
+		return err
+	})
+	return b, err
+}
+
+func (s *HTTPManifestSource) fetchOnce(ctx context.Context) ([]byte, error) {
 	client := &http.Client{Timeout: s.Timeout}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.ManifestURL, nil)
 	if err != nil {
-		return nil, err
+		return nil, util.Permanent(err)
 	}
 	req.Header.Set("User-Agent", s.UserAgent)
 
@@ -39,14 +66,15 @@ func (s *HTTPManifestSource) Fetch(ctx context.Context) (*updater.Manifest, erro
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("manifest http status: %s", resp.Status)
+		return nil, util.NewHTTPStatusError("manifest", resp)
 	}
 
-	var m updater.Manifest
-	dec := json.NewDecoder(resp.Body)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&m); err != nil {
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+	if err != nil {
 		return nil, err
 	}
-	return &m, nil
+	if len(b) > maxManifestBytes {
+		return nil, util.Permanent(fmt.Errorf("manifest too large (> %d bytes)", maxManifestBytes))
+	}
+	return b, nil
 }
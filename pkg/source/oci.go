@@ -0,0 +1,404 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+const (
+	mediaOCIIndex      = "application/vnd.oci.image.index.v1+json"
+	mediaOCIManifest   = "application/vnd.oci.image.manifest.v1+json"
+	mediaDockerList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaDockerImage   = "application/vnd.docker.distribution.manifest.v2+json"
+	annotationTitle    = "org.opencontainers.image.title"
+	annotationVersion  = "org.opencontainers.image.version"
+	annotationCreated  = "org.opencontainers.image.created"
+	annotationDescribe = "org.opencontainers.image.description"
+)
+
+// OCISource reads a release from an OCI registry (Distribution API v2).
+//
+// Tag may be a version ("1.2.3") or a channel tag ("stable"). It resolves to
+// either an index whose entries carry a platform, or one manifest whose
+// layers are titled <name>_<os>_<arch>[.exe|.tar.gz|.zip] (as pushed by
+// oras). Each blob becomes an artifact whose sha256 is the blob digest.
+// The version comes from the org.opencontainers.image.version annotation,
+// or from Tag when it looks like a version. The channel is Channel, or Tag
+// when it names one of updater.DefaultChannelOrder; "latest" has none.
+//
+// Blobs usually need the same credentials: set updater.Config.HTTPClient to
+// &http.Client{Transport: src.Transport()}.
+type OCISource struct {
+	Registry   string // base URL, e.g. https://ghcr.io or http://127.0.0.1:5000
+	Repository string // e.g. acme/agent
+	Tag        string // default latest
+	Channel    string // manifest channel; default: Tag if it is a known channel
+
+	// Token is sent as a bearer token; otherwise Username/Password are used
+	// for Basic auth or to obtain a token from the registry's auth realm.
+	Token    string
+	Username string
+	Password string
+
+	Timeout   time.Duration
+	UserAgent string
+	Retry     *util.RetryPolicy
+
+	mu     sync.Mutex
+	bearer string
+}
+
+func NewOCISource(registry, repository, tag string) *OCISource {
+	return &OCISource{
+		Registry:   registry,
+		Repository: repository,
+		Tag:        tag,
+		Timeout:    30 * time.Second,
+		UserAgent:  "portable-updater/1.0",
+		Retry:      util.DefaultRetryPolicy(),
+	}
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+// ociManifest covers both image indexes (Manifests) and image manifests
+// (Layers).
+type ociManifest struct {
+	MediaType   string            `json:"mediaType"`
+	Manifests   []ociDescriptor   `json:"manifests"`
+	Layers      []ociDescriptor   `json:"layers"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func (s *OCISource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if s.Registry == "" || s.Repository == "" {
+		return nil, errors.New("oci source: Registry and Repository are required")
+	}
+	tag := s.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	top, err := s.manifest(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &updater.Manifest{Product: s.Repository[strings.LastIndex(s.Repository, "/")+1:]}
+	ann := top.Annotations
+	if len(top.Manifests) > 0 {
+		for _, d := range top.Manifests {
+			if d.Platform == nil || d.Platform.OS == "" || d.Platform.OS == "unknown" {
+				continue // e.g. attestations
+			}
+			child, err := s.manifest(ctx, d.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if ann == nil {
+				ann = child.Annotations
+			}
+			layer, ok := pickLayer(child.Layers)
+			if !ok {
+				return nil, fmt.Errorf("oci source: %s/%s manifest has no usable layer", d.Platform.OS, d.Platform.Architecture)
+			}
+			m.Artifacts = append(m.Artifacts, s.artifact(layer, d.Platform.OS, d.Platform.Architecture))
+		}
+	} else {
+		for _, l := range top.Layers {
+			osName, arch, _, ok := platformFromName(l.Annotations[annotationTitle])
+			if !ok {
+				continue
+			}
+			m.Artifacts = append(m.Artifacts, s.artifact(l, osName, arch))
+		}
+	}
+	if len(m.Artifacts) == 0 {
+		return nil, fmt.Errorf("oci source: %s:%s has no per-platform artifacts", s.Repository, tag)
+	}
+
+	m.Version = ann[annotationVersion]
+	if m.Version == "" && looksLikeVersion(tag) {
+		m.Version = strings.TrimPrefix(tag, "v")
+	}
+	if m.Version == "" {
+		return nil, fmt.Errorf("oci source: %s:%s has no %s annotation", s.Repository, tag, annotationVersion)
+	}
+	m.Channel = s.channel(tag)
+	m.Notes = ann[annotationDescribe]
+	if t, err := time.Parse(time.RFC3339, ann[annotationCreated]); err == nil {
+		m.PublishedAt = t
+	}
+	return m, nil
+}
+
+func (s *OCISource) artifact(l ociDescriptor, osName, arch string) updater.Artifact {
+	title := l.Annotations[annotationTitle]
+	format := ""
+	if _, _, f, ok := platformFromName(title); ok {
+		format = f
+	}
+	switch {
+	case format != "":
+	case strings.Contains(l.MediaType, "tar+gzip") || strings.HasSuffix(l.MediaType, ".tar.gzip"):
+		format = util.ArchiveTarGz
+	case strings.HasSuffix(l.MediaType, "zip"):
+		format = util.ArchiveZip
+	}
+	name := title
+	if name == "" {
+		name = l.Digest
+	}
+	return updater.Artifact{
+		OS:     osName,
+		Arch:   arch,
+		Name:   name,
+		URL:    s.url("blobs", l.Digest),
+		SHA256: strings.TrimPrefix(l.Digest, "sha256:"),
+		Size:   l.Size,
+		Format: format,
+	}
+}
+
+// pickLayer returns the only layer, or the only titled one.
+func pickLayer(layers []ociDescriptor) (ociDescriptor, bool) {
+	if len(layers) == 1 {
+		return layers[0], strings.HasPrefix(layers[0].Digest, "sha256:")
+	}
+	var found []ociDescriptor
+	for _, l := range layers {
+		if l.Annotations[annotationTitle] != "" {
+			found = append(found, l)
+		}
+	}
+	if len(found) != 1 || !strings.HasPrefix(found[0].Digest, "sha256:") {
+		return ociDescriptor{}, false
+	}
+	return found[0], true
+}
+
+func (s *OCISource) channel(tag string) string {
+	if s.Channel != "" {
+		return s.Channel
+	}
+	for _, ch := range updater.DefaultChannelOrder {
+		if strings.EqualFold(tag, ch) {
+			return ch
+		}
+	}
+	return ""
+}
+
+func looksLikeVersion(tag string) bool {
+	t := strings.TrimPrefix(tag, "v")
+	return t != "" && t[0] >= '0' && t[0] <= '9'
+}
+
+func (s *OCISource) url(kind, ref string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", strings.TrimRight(s.Registry, "/"), s.Repository, kind, ref)
+}
+
+// manifest GETs a manifest by tag or digest; by digest, the body must hash
+// to it.
+func (s *OCISource) manifest(ctx context.Context, ref string) (*ociManifest, error) {
+	var body []byte
+	client := &http.Client{Timeout: s.Timeout, Transport: s.Transport()}
+	err := s.Retry.Do(ctx, func(int) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url("manifests", ref), nil)
+		if err != nil {
+			return util.Permanent(err)
+		}
+		req.Header.Set("Accept", strings.Join([]string{mediaOCIIndex, mediaOCIManifest, mediaDockerList, mediaDockerImage}, ", "))
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return util.NewHTTPStatusError("oci manifest", resp)
+		}
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > maxManifestBytes {
+			return util.Permanent(fmt.Errorf("oci source: manifest %s too large (> %d bytes)", ref, maxManifestBytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(ref, "sha256:") {
+		sum := sha256.Sum256(body)
+		if "sha256:"+hex.EncodeToString(sum[:]) != ref {
+			return nil, fmt.Errorf("oci source: manifest %s does not match its digest", ref)
+		}
+	}
+	var m ociManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("oci source: decode manifest %s: %w", ref, err)
+	}
+	return &m, nil
+}
+
+// Transport authenticates requests to the registry host, answering Basic
+// and Bearer (token) challenges. Other hosts, such as blob storage the
+// registry redirects to, get no credentials.
+func (s *OCISource) Transport() http.RoundTripper {
+	return &ociTransport{s: s, base: http.DefaultTransport}
+}
+
+type ociTransport struct {
+	s    *OCISource
+	base http.RoundTripper
+}
+
+func (t *ociTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reg, err := url.Parse(t.s.Registry)
+	if err != nil || !strings.EqualFold(req.URL.Host, reg.Host) {
+		return t.base.RoundTrip(req)
+	}
+
+	r := t.authorize(req)
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return resp, err
+	}
+
+	// answer the challenge and try once more
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if err := t.s.login(req.Context(), challenge); err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(t.authorize(req))
+}
+
+func (t *ociTransport) authorize(req *http.Request) *http.Request {
+	r := req.Clone(req.Context())
+	if t.s.UserAgent != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", t.s.UserAgent)
+	}
+	t.s.mu.Lock()
+	bearer := t.s.bearer
+	t.s.mu.Unlock()
+	switch {
+	case t.s.Token != "":
+		r.Header.Set("Authorization", "Bearer "+t.s.Token)
+	case bearer != "":
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	case t.s.Username != "":
+		r.SetBasicAuth(t.s.Username, t.s.Password)
+	}
+	return r
+}
+
+// login fetches a bearer token for a `Bearer realm=...` challenge.
+func (s *OCISource) login(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || s.Token != "" {
+		return errors.New("no usable challenge")
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return errors.New("bearer challenge without realm")
+	}
+	q := url.Values{}
+	if v := params["service"]; v != "" {
+		q.Set("service", v)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + s.Repository + ":pull"
+	}
+	q.Set("scope", scope)
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return util.Permanent(err)
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	req.Header.Set("User-Agent", s.UserAgent)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return util.NewHTTPStatusError("oci token", resp)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return err
+	}
+	if tok.Token == "" {
+		tok.Token = tok.AccessToken
+	}
+	if tok.Token == "" {
+		return errors.New("token response has no token")
+	}
+	s.mu.Lock()
+	s.bearer = tok.Token
+	s.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses `Bearer realm="...",service="...",scope="..."`.
+func parseChallenge(h string) (scheme string, params map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params = map[string]string{}
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		k, v, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(v, `"`) {
+			end := strings.Index(v[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = v[1:end+1], v[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(v, ",")
+		}
+		params[k] = kv
+	}
+	return scheme, params
+}
@@ -0,0 +1,329 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const ociToken = "tok-123"
+
+// registry is a fake Distribution API v2 server. Every /v2/ request needs
+// the bearer token handed out by /token for robot:secret.
+type registry struct {
+	*httptest.Server
+	mu        sync.Mutex
+	manifests map[string][]byte // tag or digest -> body
+	blobs     map[string][]byte // digest -> body
+	logins    int
+	scopes    []string
+}
+
+func newRegistry(t *testing.T) *registry {
+	t.Helper()
+	r := &registry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *registry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.logins++
+		r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": ociToken})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+ociToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test-registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rest, ok := strings.CutPrefix(req.URL.Path, "/v2/acme/agent/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	kind, ref, _ := strings.Cut(rest, "/")
+	var body []byte
+	switch kind {
+	case "manifests":
+		body, ok = r.manifests[ref]
+	case "blobs":
+		body, ok = r.blobs[ref]
+	}
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// blob stores b and returns its descriptor.
+func (r *registry) blob(b []byte, mediaType, title string) ociDescriptor {
+	d := ociDescriptor{MediaType: mediaType, Digest: digest(b), Size: int64(len(b))}
+	if title != "" {
+		d.Annotations = map[string]string{annotationTitle: title}
+	}
+	r.blobs[d.Digest] = b
+	return d
+}
+
+// manifest stores m under its digest and any tags and returns its digest.
+func (r *registry) manifest(t *testing.T, m ociManifest, tags ...string) string {
+	t.Helper()
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := digest(b)
+	r.manifests[d] = b
+	for _, tag := range tags {
+		r.manifests[tag] = b
+	}
+	return d
+}
+
+func (r *registry) source(tag string) *OCISource {
+	src := NewOCISource(r.URL, "acme/agent", tag)
+	src.Username, src.Password = "robot", "secret"
+	src.Retry = nil
+	return src
+}
+
+// orasRelease pushes a single manifest with one titled layer per platform.
+func orasRelease(t *testing.T, r *registry, version string, tags ...string) {
+	t.Helper()
+	m := ociManifest{MediaType: mediaOCIManifest, Layers: []ociDescriptor{
+		r.blob([]byte("linux binary"), "application/octet-stream", "agent_linux_amd64"),
+		r.blob([]byte("windows zip"), "application/zip", "agent_windows_amd64.zip"),
+		r.blob([]byte("readme"), "text/plain", "README.md"),
+	}}
+	if version != "" {
+		m.Annotations = map[string]string{annotationVersion: version, annotationCreated: "2026-10-01T12:00:00Z", annotationDescribe: "notes"}
+	}
+	r.manifest(t, m, tags...)
+}
+
+func TestOCILayersManifest(t *testing.T) {
+	r := newRegistry(t)
+	orasRelease(t, r, "1.4.0", "stable")
+	src := r.source("stable")
+
+	m, err := src.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Product != "agent" || m.Version != "1.4.0" || m.Channel != "stable" || m.Notes != "notes" || m.PublishedAt.IsZero() {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if len(m.Artifacts) != 2 {
+		t.Fatalf("got %d artifacts, want 2", len(m.Artifacts))
+	}
+	want := []struct{ os, format, body string }{{"linux", "", "linux binary"}, {"windows", "zip", "windows zip"}}
+	for i, w := range want {
+		a := m.Artifacts[i]
+		if a.OS != w.os || a.Arch != "amd64" || a.Format != w.format || "sha256:"+a.SHA256 != digest([]byte(w.body)) || a.Size != int64(len(w.body)) {
+			t.Errorf("artifact %d = %+v", i, a)
+		}
+
+		// blobs are fetched by the updater through src.Transport()
+		resp, err := (&http.Client{Transport: src.Transport()}).Get(a.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != w.body {
+			t.Errorf("blob %s: %d %q", a.URL, resp.StatusCode, b)
+		}
+	}
+	if r.logins != 1 || r.scopes[0] != "repository:acme/agent:pull" {
+		t.Fatalf("logins = %d, scopes = %v; want one pull login", r.logins, r.scopes)
+	}
+}
+
+func TestOCIIndex(t *testing.T) {
+	r := newRegistry(t)
+	platform := func(os, arch string) *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} {
+		return &struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		}{os, arch}
+	}
+	child := func(body, mediaType string) ociDescriptor {
+		b := []byte(body)
+		d := r.manifest(t, ociManifest{MediaType: mediaOCIManifest, Layers: []ociDescriptor{r.blob(b, mediaType, "")}})
+		return ociDescriptor{MediaType: mediaOCIManifest, Digest: d}
+	}
+	amd64, arm64, att := child("amd64 binary", "application/octet-stream"), child("arm64 tarball", "application/vnd.oci.image.layer.v1.tar+gzip"), child("attestation", "application/json")
+	amd64.Platform, arm64.Platform, att.Platform = platform("linux", "amd64"), platform("linux", "arm64"), platform("unknown", "unknown")
+	r.manifest(t, ociManifest{
+		MediaType:   mediaOCIIndex,
+		Manifests:   []ociDescriptor{amd64, arm64, att},
+		Annotations: map[string]string{annotationVersion: "2.0.0-beta.1"},
+	}, "beta")
+
+	m, err := r.source("beta").Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "2.0.0-beta.1" || m.Channel != "beta" || len(m.Artifacts) != 2 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if a := m.Artifacts[1]; a.Arch != "arm64" || a.Format != "tar.gz" || "sha256:"+a.SHA256 != digest([]byte("arm64 tarball")) {
+		t.Fatalf("arm64 artifact = %+v", a)
+	}
+
+	// a child whose body does not match the digest in the index is rejected
+	r.manifests[amd64.Digest] = []byte(`{"layers":[]}`)
+	if _, err := r.source("beta").Fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Fatalf("tampered child: %v", err)
+	}
+}
+
+func TestOCITagMapping(t *testing.T) {
+	tests := []struct {
+		tag, annotation, channel string
+		wantVersion, wantChannel string
+	}{
+		{"1.4.0", "", "", "1.4.0", ""},
+		{"v1.4.0", "", "", "1.4.0", ""},
+		{"1.4.0", "1.4.0+build.7", "", "1.4.0+build.7", ""},
+		{"stable", "1.4.0", "", "1.4.0", "stable"},
+		{"Nightly", "1.5.0-nightly.3", "", "1.5.0-nightly.3", "nightly"},
+		{"latest", "1.4.0", "", "1.4.0", ""},
+		{"", "1.4.0", "", "1.4.0", ""}, // "" = latest
+		{"edge", "1.5.0", "", "1.5.0", ""},
+		{"latest", "1.4.0", "beta", "1.4.0", "beta"},
+		{"1.4.0", "", "stable", "1.4.0", "stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag+"/"+tt.channel, func(t *testing.T) {
+			r := newRegistry(t)
+			tag := tt.tag
+			if tag == "" {
+				tag = "latest"
+			}
+			orasRelease(t, r, tt.annotation, tag)
+			src := r.source(tt.tag)
+			src.Channel = tt.channel
+			m, err := src.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Version != tt.wantVersion || m.Channel != tt.wantChannel {
+				t.Fatalf("got %q %q, want %q %q", m.Version, m.Channel, tt.wantVersion, tt.wantChannel)
+			}
+		})
+	}
+}
+
+func TestOCIErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*testing.T, *registry)
+		src   func(*registry) *OCISource
+		want  string
+	}{
+		{
+			name:  "channel tag without version annotation",
+			setup: func(t *testing.T, r *registry) { orasRelease(t, r, "", "stable") },
+			want:  "has no " + annotationVersion,
+		},
+		{
+			name: "no platform layers",
+			setup: func(t *testing.T, r *registry) {
+				r.manifest(t, ociManifest{Layers: []ociDescriptor{r.blob([]byte("x"), "text/plain", "README.md")}, Annotations: map[string]string{annotationVersion: "1.0.0"}}, "stable")
+			},
+			want: "no per-platform artifacts",
+		},
+		{
+			name: "manifest too large",
+			setup: func(t *testing.T, r *registry) {
+				r.manifests["stable"] = []byte(strings.Repeat(" ", maxManifestBytes+1))
+			},
+			want: "too large",
+		},
+		{
+			name:  "bad credentials",
+			setup: func(t *testing.T, r *registry) { orasRelease(t, r, "1.0.0", "stable") },
+			src: func(r *registry) *OCISource {
+				src := r.source("stable")
+				src.Password = "wrong"
+				return src
+			},
+			want: "401",
+		},
+		{
+			name:  "missing tag",
+			setup: func(t *testing.T, r *registry) {},
+			want:  "404",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRegistry(t)
+			tt.setup(t, r)
+			src := r.source("stable")
+			if tt.src != nil {
+				src = tt.src(r)
+			}
+			_, err := src.Fetch(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOCITransportScopesCredentials(t *testing.T) {
+	var got []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+
+	src := NewOCISource("https://registry.example.com", "acme/agent", "stable")
+	src.Token = "static"
+	resp, err := (&http.Client{Transport: src.Transport()}).Get(other.URL + "/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(got) != 1 || got[0] != "" {
+		t.Fatalf("credentials sent to another host: %q", got)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, p := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:acme/agent:pull,push"`)
+	if scheme != "Bearer" || p["realm"] != "https://auth.example.com/token" || p["service"] != "registry.example.com" || p["scope"] != "repository:acme/agent:pull,push" {
+		t.Fatalf("got %q %v", scheme, p)
+	}
+	scheme, p = parseChallenge(`Basic realm=registry`)
+	if scheme != "Basic" || p["realm"] != "registry" {
+		t.Fatalf("got %q %v", scheme, p)
+	}
+}
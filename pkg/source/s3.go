@@ -0,0 +1,358 @@
+package source
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// S3Config addresses an S3-compatible service (AWS, MinIO, ...).
+type S3Config struct {
+	Endpoint  string // default https://s3.<Region>.amazonaws.com; MinIO e.g. http://minio:9000
+	Region    string // default $AWS_REGION, then us-east-1
+	PathStyle bool   // https://host/bucket/key instead of https://bucket.host/key
+
+	// static credentials; when empty AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN are read on every request
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Base carries the requests Transport sends; default
+	// http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// S3Source reads the manifest from s3://bucket/key. Artifact, patch and
+// signature URLs may be s3:// URLs or keys relative to the manifest.
+//
+// Downloads of s3:// URLs need the signing transport: set
+// updater.Config.HTTPClient to &http.Client{Transport: src.Transport()}.
+type S3Source struct {
+	S3Config
+	Bucket string
+	Key    string
+
+	Timeout   time.Duration
+	UserAgent string
+	Retry     *util.RetryPolicy
+}
+
+// NewS3Source parses an s3://bucket/key manifest URL.
+func NewS3Source(rawURL string, cfg S3Config) (*S3Source, error) {
+	bucket, key, err := parseS3URL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Source{
+		S3Config:  cfg,
+		Bucket:    bucket,
+		Key:       key,
+		Timeout:   30 * time.Second,
+		UserAgent: "portable-updater/1.0",
+		Retry:     util.DefaultRetryPolicy(),
+	}, nil
+}
+
+// NewSignedS3Source expects the detached signature at <key>.sig.
+func NewSignedS3Source(rawURL string, cfg S3Config, v verify.SignatureVerifier) (*SignedSource, error) {
+	s, err := NewS3Source(rawURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedSource{Manifest: s, Signature: s.Object(s.Key + ".sig"), Verifier: v}, nil
+}
+
+func (s *S3Source) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	b, err := s.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := decodeManifest(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolveURLs(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *S3Source) FetchRaw(ctx context.Context) ([]byte, error) {
+	return s.Object(s.Key).FetchRaw(ctx)
+}
+
+// Object returns a RawSource for another key in the bucket.
+func (s *S3Source) Object(key string) RawSource {
+	return s3Object{s: s, key: key}
+}
+
+type s3Object struct {
+	s   *S3Source
+	key string
+}
+
+func (o s3Object) FetchRaw(ctx context.Context) ([]byte, error) {
+	u := "s3://" + o.s.Bucket + "/" + o.key
+	client := &http.Client{Timeout: o.s.Timeout, Transport: o.s.Transport()}
+	var out []byte
+	err := o.s.Retry.Do(ctx, func(int) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return util.Permanent(err)
+		}
+		req.Header.Set("User-Agent", o.s.UserAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return util.NewHTTPStatusError("s3", resp)
+		}
+		out, err = io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(out) > maxManifestBytes {
+			return util.Permanent(fmt.Errorf("s3: %s too large (> %d bytes)", u, maxManifestBytes))
+		}
+		return nil
+	})
+	return out, err
+}
+
+// resolveURLs turns keys relative to the manifest into s3:// URLs.
+func (s *S3Source) resolveURLs(m *updater.Manifest) error {
+	dir := path.Dir(s.Key)
+	resolve := func(u *string) {
+		if *u == "" || strings.Contains(*u, "://") {
+			return
+		}
+		*u = "s3://" + s.Bucket + "/" + strings.TrimPrefix(path.Join(dir, *u), "/")
+	}
+	for i := range m.Artifacts {
+		a := &m.Artifacts[i]
+		resolve(&a.URL)
+		resolve(&a.SignatureURL)
+		for j := range a.Mirrors {
+			resolve(&a.Mirrors[j].URL)
+		}
+	}
+	for i := range m.Patches {
+		resolve(&m.Patches[i].URL)
+	}
+	for i := range m.Releases {
+		s.resolveURLs(&m.Releases[i])
+	}
+	return nil
+}
+
+// Transport serves s3://bucket/key URLs from the configured endpoint with
+// SigV4-signed requests; other URLs go through Base unchanged.
+func (c S3Config) Transport() http.RoundTripper {
+	base := c.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &s3Transport{cfg: c, base: base, now: time.Now}
+}
+
+type s3Transport struct {
+	cfg  S3Config
+	base http.RoundTripper
+	now  func() time.Time
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "s3" {
+		return t.base.RoundTrip(req)
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return nil, errors.New("s3: request bodies are not supported")
+	}
+	bucket := req.URL.Host
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("s3: bad url %q", req.URL.String())
+	}
+
+	endpoint, err := url.Parse(t.cfg.endpoint())
+	if err != nil {
+		return nil, fmt.Errorf("s3: bad endpoint: %w", err)
+	}
+	r := req.Clone(req.Context())
+	u := &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host}
+	base := strings.TrimSuffix(endpoint.Path, "/")
+	if t.cfg.PathStyle {
+		u.Path = base + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + endpoint.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = req.URL.RawQuery
+	r.URL = u
+	r.Host = u.Host
+
+	creds := t.cfg.credentials()
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("s3: no credentials (set AccessKeyID/SecretAccessKey or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+	signV4(r, creds, t.cfg.region(), "s3", t.now())
+	return t.base.RoundTrip(r)
+}
+
+func (c S3Config) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	return "us-east-1"
+}
+
+func (c S3Config) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return "https://s3." + c.region() + ".amazonaws.com"
+}
+
+func (c S3Config) credentials() S3Config {
+	if c.AccessKeyID != "" {
+		return c
+	}
+	c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	return c
+}
+
+func parseS3URL(raw string) (bucket, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("not an s3://bucket/key url: %q", raw)
+	}
+	return u.Host, key, nil
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 adds AWS Signature Version 4 headers to a request without a body.
+// Host, Range and the x-amz-* headers are signed.
+func signV4(r *http.Request, c S3Config, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if c.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": r.URL.Host}
+	for k, v := range r.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		r.Method,
+		s3EscapePath(r.URL.Path),
+		canonicalQuery(r.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		emptySHA256,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EscapePath URI-encodes each path segment the way SigV4 expects.
+func s3EscapePath(p string) string {
+	return s3Escape(p, false)
+}
+
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
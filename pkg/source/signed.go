@@ -0,0 +1,172 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/blitzh/go-autoupdater/pkg/updater"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// RawSource returns undecoded bytes (a manifest, an envelope or a signature).
+type RawSource interface {
+	FetchRaw(ctx context.Context) ([]byte, error)
+}
+
+// Envelope is a manifest and its signature in one document. Signed holds the
+// manifest JSON exactly as it was signed; KeyID optionally names the key.
+type Envelope struct {
+	Signed    json.RawMessage `json:"signed"`
+	Signature string          `json:"signature"`
+	KeyID     string          `json:"keyid,omitempty"`
+}
+
+// SignedSource only returns a manifest once its Ed25519 signature verifies.
+//
+// With Signature set, Manifest returns the plain manifest and Signature the
+// detached signature over those exact bytes. With Signature nil, Manifest
+// must return an Envelope.
+//
+// The signing key is taken from Envelope.KeyID or the manifest's key_id; when
+// named and Verifier is a verify.KeyedVerifier (e.g. *verify.KeyRing), only
+// that key is accepted. Rotation, if set, is fetched and applied to the
+// *verify.KeyRing Verifier before the manifest is checked; the ring must come
+// from verify.LoadKeyRing, since an in-memory ring would forget revocations
+// and trust the bootstrap keys again on the next start.
+type SignedSource struct {
+	Manifest  RawSource
+	Signature RawSource
+	Rotation  RawSource
+	Verifier  verify.SignatureVerifier
+}
+
+// NewSignedHTTPManifestSource expects the detached signature at
+// SignatureURL(url).
+func NewSignedHTTPManifestSource(url string, v verify.SignatureVerifier) *SignedSource {
+	return &SignedSource{
+		Manifest:  NewHTTPManifestSource(url),
+		Signature: NewHTTPManifestSource(SignatureURL(url)),
+		Verifier:  v,
+	}
+}
+
+// SignatureURL is the default detached signature location for a manifest:
+// ".sig" appended to the URL's path, keeping any query string (e.g. of a
+// presigned URL). Plain file paths just get ".sig".
+func SignatureURL(manifestURL string) string {
+	u, err := url.Parse(manifestURL)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		return manifestURL + ".sig"
+	}
+	u.Path += ".sig"
+	if u.RawPath != "" {
+		u.RawPath += ".sig"
+	}
+	return u.String()
+}
+
+func (s *SignedSource) Fetch(ctx context.Context) (*updater.Manifest, error) {
+	if s.Manifest == nil {
+		return nil, errors.New("Manifest source is nil")
+	}
+	if s.Verifier == nil {
+		return nil, errors.New("Verifier is nil")
+	}
+
+	if s.Rotation != nil {
+		if err := s.applyRotation(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := s.Manifest.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var body, sig []byte
+	var keyID string
+	if s.Signature == nil {
+		body, sig, keyID, err = openEnvelope(raw)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sigRaw, err := s.Signature.FetchRaw(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetch manifest signature: %w", err)
+		}
+		if sig, err = verify.DecodeSignature(sigRaw); err != nil {
+			return nil, err
+		}
+		body = raw
+	}
+
+	if keyID == "" {
+		// unauthenticated hint; only used to pick the key to verify with
+		var hint struct {
+			KeyID string `json:"key_id"`
+		}
+		_ = json.Unmarshal(body, &hint)
+		keyID = hint.KeyID
+	}
+
+	if kv, ok := s.Verifier.(verify.KeyedVerifier); ok && keyID != "" {
+		err = kv.VerifyKey(keyID, body, sig)
+	} else {
+		err = s.Verifier.Verify(body, sig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest signature: %w", err)
+	}
+
+	m, err := decodeManifest(body)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != "" && m.KeyID != "" && m.KeyID != keyID {
+		return nil, fmt.Errorf("manifest key_id %q does not match signing key %q", m.KeyID, keyID)
+	}
+	if r, ok := s.Manifest.(urlResolver); ok {
+		if err := r.resolveURLs(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (s *SignedSource) applyRotation(ctx context.Context) error {
+	ring, ok := s.Verifier.(*verify.KeyRing)
+	if !ok {
+		return errors.New("key rotation requires a *verify.KeyRing Verifier")
+	}
+	if ring.Path() == "" {
+		return errors.New("key rotation requires a key ring loaded from a file (verify.LoadKeyRing) to persist it")
+	}
+	b, err := s.Rotation.FetchRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("fetch key rotation: %w", err)
+	}
+	return ring.ApplyRotation(b)
+}
+
+func openEnvelope(raw []byte) (body, sig []byte, keyID string, err error) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, nil, "", fmt.Errorf("decode manifest envelope: %w", err)
+	}
+	if len(env.Signed) == 0 {
+		return nil, nil, "", errors.New("manifest envelope has no signed payload")
+	}
+	if env.Signature == "" {
+		return nil, nil, "", errors.New("manifest envelope has no signature")
+	}
+	sig, err = verify.DecodeSignature([]byte(env.Signature))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return env.Signed, sig, env.KeyID, nil
+}
@@ -0,0 +1,163 @@
+package source
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// rawBytes is a RawSource serving fixed bytes.
+type rawBytes []byte
+
+func (r rawBytes) FetchRaw(context.Context) ([]byte, error) { return r, nil }
+
+type rawErr struct{ err error }
+
+func (r rawErr) FetchRaw(context.Context) ([]byte, error) { return nil, r.err }
+
+func genKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func envelope(t *testing.T, body []byte, sig []byte, keyID string) rawBytes {
+	t.Helper()
+	b, err := json.Marshal(Envelope{Signed: body, Signature: base64.StdEncoding.EncodeToString(sig), KeyID: keyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSignedSource(t *testing.T) {
+	pub, priv := genKey(t)
+	otherPub, otherPriv := genKey(t)
+	ring, err := verify.NewKeyRing(verify.TrustedKey{ID: "k1", PublicKey: pub}, verify.TrustedKey{ID: "k2", PublicKey: otherPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"product":"agent","channel":"stable","version":"1.2.0","published_at":"2026-01-01T00:00:00Z","notes":"","artifacts":[]}`)
+	withKeyID := []byte(`{"product":"agent","channel":"stable","version":"1.2.0","published_at":"2026-01-01T00:00:00Z","notes":"","artifacts":[],"key_id":"k1"}`)
+	sig := ed25519.Sign(priv, body)
+	b64 := rawBytes(base64.StdEncoding.EncodeToString(sig))
+
+	tests := []struct {
+		name    string
+		src     *SignedSource
+		wantErr error // nil, verify.ErrSignatureInvalid, verify.ErrUnknownKey or errAny
+	}{
+		{"detached base64", &SignedSource{Manifest: rawBytes(body), Signature: b64, Verifier: verify.NewEd25519Verifier(pub)}, nil},
+		{"detached raw", &SignedSource{Manifest: rawBytes(body), Signature: rawBytes(sig), Verifier: verify.NewEd25519Verifier(pub)}, nil},
+		{"detached tampered", &SignedSource{Manifest: rawBytes(append([]byte(" "), body...)), Signature: b64, Verifier: verify.NewEd25519Verifier(pub)}, verify.ErrSignatureInvalid},
+		{"detached wrong key", &SignedSource{Manifest: rawBytes(body), Signature: b64, Verifier: verify.NewEd25519Verifier(otherPub)}, verify.ErrSignatureInvalid},
+		{"detached fetch error", &SignedSource{Manifest: rawBytes(body), Signature: rawErr{errors.New("404")}, Verifier: verify.NewEd25519Verifier(pub)}, errAny},
+		{"envelope", &SignedSource{Manifest: envelope(t, body, sig, ""), Verifier: verify.NewEd25519Verifier(pub)}, nil},
+		{"envelope keyid", &SignedSource{Manifest: envelope(t, body, sig, "k1"), Verifier: ring}, nil},
+		{"envelope other keyid", &SignedSource{Manifest: envelope(t, body, sig, "k2"), Verifier: ring}, verify.ErrSignatureInvalid},
+		{"envelope unknown keyid", &SignedSource{Manifest: envelope(t, body, sig, "k9"), Verifier: ring}, verify.ErrUnknownKey},
+		{"envelope other signer", &SignedSource{Manifest: envelope(t, body, ed25519.Sign(otherPriv, body), ""), Verifier: verify.NewEd25519Verifier(pub)}, verify.ErrSignatureInvalid},
+		{"envelope no signature", &SignedSource{Manifest: rawBytes(`{"signed":{}}`), Verifier: verify.NewEd25519Verifier(pub)}, errAny},
+		{"envelope no payload", &SignedSource{Manifest: rawBytes(`{"signature":"AAAA"}`), Verifier: verify.NewEd25519Verifier(pub)}, errAny},
+		{"plain manifest without signature", &SignedSource{Manifest: rawBytes(body), Verifier: verify.NewEd25519Verifier(pub)}, errAny},
+		{"manifest key_id picks key", &SignedSource{Manifest: rawBytes(withKeyID), Signature: rawBytes(ed25519.Sign(priv, withKeyID)), Verifier: ring}, nil},
+		{"manifest key_id vs envelope keyid", &SignedSource{Manifest: envelope(t, withKeyID, ed25519.Sign(otherPriv, withKeyID), "k2"), Verifier: ring}, errAny},
+		{"no verifier", &SignedSource{Manifest: rawBytes(body), Signature: b64}, errAny},
+		{"no manifest", &SignedSource{Verifier: verify.NewEd25519Verifier(pub)}, errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.src.Fetch(context.Background())
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr == nil:
+				if m.Version != "1.2.0" {
+					t.Fatalf("version = %q", m.Version)
+				}
+			case err == nil:
+				t.Fatal("want an error")
+			case tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignedSourceRotation(t *testing.T) {
+	rootPub, rootPriv := genKey(t)
+	nextPub, nextPriv := genKey(t)
+	root := verify.TrustedKey{ID: "root", PublicKey: rootPub}
+
+	doc, err := json.Marshal(verify.RotationDoc{Version: 1, Keys: []verify.TrustedKey{{ID: "next", PublicKey: nextPub}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rot, err := json.Marshal(verify.SignedRotation{Signed: doc, Signatures: []verify.KeySignature{
+		{KeyID: "root", Sig: base64.StdEncoding.EncodeToString(ed25519.Sign(rootPriv, doc))},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a manifest signed by the key the rotation introduces
+	body := []byte(`{"product":"agent","version":"1.2.0","published_at":"2026-01-01T00:00:00Z","artifacts":[],"key_id":"next"}`)
+	src := func(ring *verify.KeyRing) *SignedSource {
+		return &SignedSource{Manifest: rawBytes(body), Signature: rawBytes(ed25519.Sign(nextPriv, body)), Rotation: rawBytes(rot), Verifier: ring}
+	}
+
+	// an in-memory ring would trust the bootstrap keys again after a restart
+	mem, err := verify.NewKeyRing(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src(mem).Fetch(context.Background()); err == nil {
+		t.Fatal("rotation applied to a ring without a file")
+	}
+	if _, err := (&SignedSource{Manifest: rawBytes(body), Rotation: rawBytes(rot), Verifier: verify.NewEd25519Verifier(rootPub)}).Fetch(context.Background()); err == nil {
+		t.Fatal("rotation applied to a verifier that is not a key ring")
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ring, err := verify.LoadKeyRing(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src(ring).Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ring, err = verify.LoadKeyRing(path, root); err != nil {
+		t.Fatal(err)
+	}
+	if len(ring.Keys()) != 2 {
+		t.Fatalf("persisted keys = %+v, want root and next", ring.Keys())
+	}
+}
+
+func TestSignatureURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://example.com/agent/manifest.json", "https://example.com/agent/manifest.json.sig"},
+		{"https://example.com/manifest.json?X-Amz-Signature=abc&X-Amz-Expires=60", "https://example.com/manifest.json.sig?X-Amz-Signature=abc&X-Amz-Expires=60"},
+		{"https://example.com/a%20b/manifest.json?x=1", "https://example.com/a%20b/manifest.json.sig?x=1"},
+		{"s3://bucket/agent/manifest.json", "s3://bucket/agent/manifest.json.sig"},
+		{"/opt/agent/manifest.json", "/opt/agent/manifest.json.sig"},
+		{`C:\updates\manifest.json`, `C:\updates\manifest.json.sig`},
+	}
+	for _, tt := range tests {
+		if got := SignatureURL(tt.in); got != tt.want {
+			t.Errorf("SignatureURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// errAny matches any non-nil error.
+var errAny = errors.New("any error")
@@ -0,0 +1,221 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+const backupMetaName = "backup.json"
+
+// Backup is a previous binary retained in Config.BackupDir.
+type Backup struct {
+	Version     string    `json:"version"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	InstalledAt time.Time `json:"installed_at"`
+	BackedUpAt  time.Time `json:"backed_up_at"`
+
+	Path string `json:"-"` // the binary
+}
+
+func (u *Updater) backupDir() string {
+	if u.cfg.BackupDir != "" {
+		return u.cfg.BackupDir
+	}
+	return filepath.Join(u.cfg.InstallDir, strings.TrimSuffix(u.cfg.ExeName, ".exe")+".backups")
+}
+
+// Backups lists the retained backups, newest first.
+func (u *Updater) Backups() ([]Backup, error) {
+	dir := u.backupDir()
+	ents, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Backup
+	for _, e := range ents {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name(), backupMetaName))
+		if err != nil {
+			continue // not a backup, or an incomplete one
+		}
+		var bk Backup
+		if err := json.Unmarshal(b, &bk); err != nil {
+			continue
+		}
+		bk.Path = filepath.Join(dir, e.Name(), u.cfg.ExeName)
+		out = append(out, bk)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].BackedUpAt.After(out[j].BackedUpAt) })
+	return out, nil
+}
+
+// keepBackup copies the replaced binary into the backup directory and
+// prunes old entries. BackupKeep < 0 disables versioned backups.
+func (u *Updater) keepBackup(path, version string) error {
+	if u.cfg.BackupKeep < 0 {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // first install, nothing replaced
+	}
+	if err != nil {
+		return err
+	}
+	sum, err := verify.FileSHA256Hex(path)
+	if err != nil {
+		return err
+	}
+	bk := Backup{Version: version, SHA256: sum, Size: fi.Size(), InstalledAt: fi.ModTime().UTC(), BackedUpAt: time.Now().UTC()}
+	if st, err := loadState(u.cfg.StateFile); err == nil && st.InstalledVersion == version && !st.InstalledAt.IsZero() {
+		bk.InstalledAt = st.InstalledAt
+	}
+
+	name := backupName(version) + "_" + sum[:12]
+	dir := filepath.Join(u.backupDir(), name)
+	_, statErr := os.Stat(dir)
+	created := errors.Is(statErr, os.ErrNotExist)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// backup.json goes last: until it is written Backups skips the entry
+	err = util.CopyFile(path, filepath.Join(dir, u.cfg.ExeName))
+	if err == nil {
+		var meta []byte
+		if meta, err = json.MarshalIndent(bk, "", "  "); err == nil {
+			err = os.WriteFile(filepath.Join(dir, backupMetaName), meta, 0644)
+		}
+	}
+	if err != nil {
+		if created {
+			_ = os.RemoveAll(dir)
+		}
+		return err
+	}
+	u.logf("kept backup of %s in %s", orUnknown(version), dir)
+	return u.pruneBackups()
+}
+
+// pruneBackups keeps the newest BackupKeep (default 3) backups and drops
+// older ones while the total exceeds BackupMaxBytes; the newest is always
+// kept. Only backups listed by Backups are removed; anything else in the
+// backup directory is left alone.
+func (u *Updater) pruneBackups() error {
+	keep := u.cfg.BackupKeep
+	if keep == 0 {
+		keep = 3
+	}
+	all, err := u.Backups()
+	if err != nil {
+		return err
+	}
+	var total int64
+	var errs []error
+	for i, b := range all {
+		total += b.Size
+		if i < keep && (i == 0 || u.cfg.BackupMaxBytes <= 0 || total <= u.cfg.BackupMaxBytes) {
+			continue
+		}
+		total -= b.Size
+		dir := filepath.Dir(b.Path)
+		u.logf("pruning backup %s", filepath.Base(dir))
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RollbackTo installs the retained backup of version (the newest one if
+// several) through the Applier. The replaced binary becomes the .old
+// backup and its version is recorded as rolled back.
+func (u *Updater) RollbackTo(ctx context.Context, version string) (*RollbackResult, error) {
+	all, err := u.Backups()
+	if err != nil {
+		return nil, err
+	}
+	var bk *Backup
+	for i := range all {
+		if c, err := u.cfg.VersionComparer.Compare(all[i].Version, version); err == nil && c == 0 {
+			bk = &all[i]
+			break
+		}
+	}
+	if bk == nil {
+		return nil, fmt.Errorf("%w: version %s", ErrNoBackup, version)
+	}
+	if err := verify.VerifyFileSHA256(bk.Path, bk.SHA256); err != nil {
+		return nil, fmt.Errorf("backup %s: %w", bk.Version, err)
+	}
+
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	res := &RollbackResult{FromVersion: st.InstalledVersion, ToVersion: bk.Version, Path: u.currentPath()}
+	if res.FromVersion == "" {
+		res.FromVersion = u.cfg.CurrentVersion
+	}
+
+	newPath, oldPath := u.stagingPaths()
+	if err := util.CopyFile(bk.Path, newPath); err != nil {
+		return nil, err
+	}
+	u.logf("rolling back %s -> %s from %s", res.FromVersion, res.ToVersion, bk.Path)
+	if u.cfg.Applier == nil {
+		return nil, fmt.Errorf("Applier is nil")
+	}
+	j, err := u.beginSwap(opRollback, res.FromVersion, res.ToVersion, newPath, oldPath)
+	if err != nil {
+		return nil, err
+	}
+	// the replaced binary becomes the .old backup
+	j.BackupVersion = res.FromVersion
+	if _, err := u.swap(ctx, j); err != nil {
+		return nil, fmt.Errorf("rollback: %w", err)
+	}
+
+	if err := u.recordRollback(res.FromVersion, res.ToVersion, res.FromVersion); err != nil {
+		return res, fmt.Errorf("record rollback: %w", err)
+	}
+	_ = j.done()
+	u.logf("rolled back to %s", res.ToVersion)
+	return res, nil
+}
+
+// backupName makes a version safe for use in a directory name.
+func backupName(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '-', r == '+':
+			return r
+		}
+		return '_'
+	}, version)
+}
+
+func orUnknown(v string) string {
+	if v == "" {
+		return "(unknown version)"
+	}
+	return v
+}
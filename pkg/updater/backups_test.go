@@ -0,0 +1,205 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeBackup stores body as a backup of version taken at the given time.
+func writeBackup(t *testing.T, u *Updater, version, body string, at time.Time) string {
+	t.Helper()
+	dir := filepath.Join(u.backupDir(), backupName(version)+"_"+sha256Hex(body)[:12])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, u.cfg.ExeName), []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := json.Marshal(Backup{Version: version, SHA256: sha256Hex(body), Size: int64(len(body)), BackedUpAt: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, backupMetaName), meta, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func backupVersions(t *testing.T, u *Updater) []string {
+	t.Helper()
+	all, err := u.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, b := range all {
+		out = append(out, b.Version)
+	}
+	return out
+}
+
+func TestKeepBackup(t *testing.T) {
+	dir := install(t, map[string]string{"agent.old": prevBin})
+	u := New(Config{InstallDir: dir, ExeName: "agent"})
+	if err := u.recordInstall("1.0.0", ""); err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.keepBackup(filepath.Join(dir, "agent.old"), "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	all, err := u.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("backups = %+v, want one", all)
+	}
+	b := all[0]
+	if b.Version != "1.0.0" || b.SHA256 != sha256Hex(prevBin) || b.Size != int64(len(prevBin)) || !b.InstalledAt.Equal(st.InstalledAt) {
+		t.Fatalf("backup = %+v", b)
+	}
+	if got := readFile(t, b.Path); got != prevBin {
+		t.Fatalf("backup binary = %q", got)
+	}
+
+	// nothing replaced on a first install
+	if err := u.keepBackup(filepath.Join(dir, "missing"), "0.9.0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := backupVersions(t, u); len(got) != 1 {
+		t.Fatalf("backups = %v after backing up a missing file", got)
+	}
+
+	// BackupKeep < 0 keeps nothing
+	off := install(t, map[string]string{"agent.old": prevBin})
+	u = New(Config{InstallDir: off, ExeName: "agent", BackupKeep: -1})
+	if err := u.keepBackup(filepath.Join(off, "agent.old"), "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(u.backupDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("backup directory created with BackupKeep < 0")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name     string
+		keep     int
+		maxBytes int64
+		want     []string // newest first
+	}{
+		{"default keeps three", 0, 0, []string{"1.4.0", "1.3.0", "1.2.0"}},
+		{"keep one", 1, 0, []string{"1.4.0"}},
+		{"keep more than there are", 10, 0, []string{"1.4.0", "1.3.0", "1.2.0", "1.1.0", "1.0.0"}},
+		{"size limit", 10, 25, []string{"1.4.0", "1.3.0"}},
+		{"size limit below the newest", 10, 5, []string{"1.4.0"}},
+		{"count before size", 1, 1000, []string{"1.4.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", BackupKeep: tt.keep, BackupMaxBytes: tt.maxBytes})
+			for i, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+				writeBackup(t, u, v, "binary "+v, now.Add(time.Duration(i)*time.Minute)) // 12 bytes each
+			}
+			// not backups: no metadata, unreadable metadata, a plain file
+			foreign := []string{
+				filepath.Join(u.backupDir(), "partial", "agent"),
+				filepath.Join(u.backupDir(), "corrupt", backupMetaName),
+				filepath.Join(u.backupDir(), "README"),
+			}
+			for _, p := range foreign {
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte("{not json"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := u.pruneBackups(); err != nil {
+				t.Fatal(err)
+			}
+			if got := backupVersions(t, u); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			for _, p := range foreign {
+				if _, err := os.Stat(p); err != nil {
+					t.Errorf("%s removed: %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRollbackTo(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		version string
+		tamper  bool
+		wantErr error // nil, ErrNoBackup or errAny
+		want    string
+	}{
+		{name: "newest of that version", version: "1.0.0", want: "1.0.0 rebuilt"},
+		{name: "older version", version: "0.9.0", want: "0.9.0 build"},
+		{name: "no such version", version: "0.8.0", wantErr: ErrNoBackup},
+		{name: "tampered backup", version: "1.0.0", tamper: true, wantErr: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := install(t, map[string]string{"agent": nextBin})
+			svc := &countingService{}
+			u := New(Config{InstallDir: dir, ExeName: "agent", Applier: renameApplier{}, Service: svc})
+			if err := u.recordInstall("2.0.0", ""); err != nil {
+				t.Fatal(err)
+			}
+			writeBackup(t, u, "0.9.0", "0.9.0 build", now.Add(-3*time.Hour))
+			writeBackup(t, u, "1.0.0", "1.0.0 build", now.Add(-2*time.Hour))
+			newest := writeBackup(t, u, "1.0.0", "1.0.0 rebuilt", now.Add(-time.Hour))
+			if tt.tamper {
+				if err := os.WriteFile(filepath.Join(newest, "agent"), []byte("tampered"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := u.RollbackTo(context.Background(), tt.version)
+			if !matchErr(err, tt.wantErr) {
+				t.Fatalf("RollbackTo = %+v, %v; want %v", res, err, tt.wantErr)
+			}
+			if err != nil {
+				if got := readFile(t, filepath.Join(dir, "agent")); got != nextBin || svc.starts != 0 {
+					t.Fatalf("current binary = %q after %d starts, want it untouched", got, svc.starts)
+				}
+				return
+			}
+			if res.FromVersion != "2.0.0" || res.ToVersion != tt.version {
+				t.Fatalf("result = %+v", res)
+			}
+			if got := readFile(t, filepath.Join(dir, "agent")); got != tt.want {
+				t.Fatalf("current binary = %q, want %q", got, tt.want)
+			}
+			if got := readFile(t, filepath.Join(dir, "agent.old")); got != nextBin {
+				t.Fatalf(".old = %q, want the replaced binary", got)
+			}
+			st, err := loadState(u.cfg.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.InstalledVersion != tt.version {
+				t.Fatalf("installed version = %s, want %s", st.InstalledVersion, tt.version)
+			}
+		})
+	}
+}
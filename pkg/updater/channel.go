@@ -0,0 +1,84 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrProductMismatch = errors.New("manifest is for another product")
+	ErrChannelMismatch = errors.New("manifest channel is not accepted")
+)
+
+// MismatchError is returned by Check when the manifest's product or channel
+// does not match Config.Product / Config.Channel. errors.Is matches
+// ErrProductMismatch or ErrChannelMismatch.
+type MismatchError struct {
+	Err       error
+	Want, Got string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%v: want %q, got %q", e.Err, e.Want, e.Got)
+}
+
+func (e *MismatchError) Unwrap() error { return e.Err }
+
+// DefaultChannelOrder ranks channels from most to least stable.
+var DefaultChannelOrder = []string{"stable", "beta", "nightly"}
+
+// checkTarget rejects a manifest for another product, or from a channel
+// less stable than Config.Channel, unless explicitly allowed.
+func (u *Updater) checkTarget(m *Manifest) error {
+	if !u.cfg.AllowProductMismatch && u.cfg.Product != "" && m.Product != "" && u.cfg.Product != m.Product {
+		return &MismatchError{Err: ErrProductMismatch, Want: u.cfg.Product, Got: m.Product}
+	}
+	if !u.cfg.AllowChannelMismatch && !u.channelAccepts(m.Channel) {
+		return &MismatchError{Err: ErrChannelMismatch, Want: u.channel(), Got: m.Channel}
+	}
+	return nil
+}
+
+func (u *Updater) channelOrder() []string {
+	if len(u.cfg.ChannelOrder) == 0 {
+		return DefaultChannelOrder
+	}
+	return u.cfg.ChannelOrder
+}
+
+// channel is Config.Channel, or the most stable channel when it is empty.
+func (u *Updater) channel() string {
+	if u.cfg.Channel == "" {
+		return u.channelOrder()[0]
+	}
+	return u.cfg.Channel
+}
+
+// channelAccepts reports whether a client on Config.Channel (empty =
+// stable) may install a release from channel got: the same channel or a
+// more stable one in Config.ChannelOrder. Channels outside the order only
+// accept themselves and the most stable channel.
+func (u *Updater) channelAccepts(got string) bool {
+	order := u.channelOrder()
+	want := u.channel()
+	if got == "" {
+		got = order[0]
+	}
+	if strings.EqualFold(want, got) {
+		return true
+	}
+	rank := func(ch string) int {
+		for i, c := range order {
+			if strings.EqualFold(c, ch) {
+				return i
+			}
+		}
+		return -1
+	}
+	w, g := rank(want), rank(got)
+	if w < 0 {
+		return g == 0
+	}
+	return g >= 0 && g <= w
+}
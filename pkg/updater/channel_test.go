@@ -0,0 +1,98 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTargetChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		order   []string
+		allow   bool
+		got     string
+		want    bool
+	}{
+		{"", nil, false, "", true},
+		{"", nil, false, "stable", true},
+		{"", nil, false, "beta", false}, // empty means stable
+		{"stable", nil, false, "beta", false},
+		{"beta", nil, false, "stable", true},
+		{"beta", nil, false, "Beta", true},
+		{"beta", nil, false, "nightly", false},
+		{"nightly", nil, false, "beta", true},
+		{"edge", nil, false, "edge", true},
+		{"edge", nil, false, "stable", true},
+		{"edge", nil, false, "beta", false},
+		{"stable", nil, false, "edge", false},
+		{"", []string{"lts", "current"}, false, "current", false},
+		{"", []string{"lts", "current"}, false, "lts", true},
+		{"", nil, true, "nightly", true},
+		{"stable", nil, true, "edge", true},
+	}
+	for _, tt := range tests {
+		u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", Channel: tt.channel, ChannelOrder: tt.order, AllowChannelMismatch: tt.allow})
+		err := u.checkTarget(&Manifest{Channel: tt.got})
+		if tt.want != (err == nil) {
+			t.Errorf("client %q (allow=%v) manifest %q: got %v, want accepted=%v", tt.channel, tt.allow, tt.got, err, tt.want)
+		}
+		var me *MismatchError
+		if err != nil && (!errors.As(err, &me) || !errors.Is(err, ErrChannelMismatch) || me.Want == "") {
+			t.Errorf("client %q manifest %q: error %v is not a channel MismatchError naming the wanted channel", tt.channel, tt.got, err)
+		}
+	}
+}
+
+func TestSelectRelease(t *testing.T) {
+	idx := &Manifest{
+		Product: "agent",
+		Channel: "stable", // releases without a channel inherit it
+		Releases: []Manifest{
+			{Version: "1.4.3"},
+			{Version: "1.5.0", Channel: "stable"},
+			{Version: "1.6.0-beta.1", Channel: "beta"},
+			{Version: "1.7.0-nightly.2", Channel: "nightly"},
+			{Version: "2.0.0-edge.1", Channel: "edge"},
+		},
+	}
+	tests := []struct {
+		name        string
+		cfg         Config
+		want        string // "" = ErrNoRelease
+		wantChannel string
+	}{
+		{"empty channel is stable", Config{}, "1.5.0", "stable"},
+		{"stable", Config{Channel: "stable"}, "1.5.0", "stable"},
+		{"beta", Config{Channel: "beta"}, "1.6.0-beta.1", "beta"},
+		{"nightly", Config{Channel: "nightly"}, "1.7.0-nightly.2", "nightly"},
+		{"unknown channel", Config{Channel: "edge"}, "2.0.0-edge.1", "edge"},
+		{"mismatch allowed", Config{AllowChannelMismatch: true}, "2.0.0-edge.1", "edge"},
+		{"constraint", Config{Channel: "nightly", VersionConstraint: "~1.4"}, "1.4.3", "stable"},
+		{"constraint in channel", Config{VersionConstraint: ">=1.6"}, "", ""},
+		{"pin ignores channel", Config{PinVersion: "1.6.0-beta.1"}, "1.6.0-beta.1", "beta"},
+		{"pin missing", Config{PinVersion: "9.9.9"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.InstallDir, tt.cfg.ExeName = t.TempDir(), "agent"
+			u := New(tt.cfg)
+			m, err := u.selectRelease(idx)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNoRelease) {
+					t.Fatalf("got %v, %v; want ErrNoRelease", m, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Version != tt.want || m.Channel != tt.wantChannel || m.Product != "agent" {
+				t.Fatalf("got %s %s %s, want agent %s %s", m.Product, m.Version, m.Channel, tt.want, tt.wantChannel)
+			}
+			// the selected release passes the single-manifest check too
+			if err := u.checkTarget(m); tt.cfg.PinVersion == "" && err != nil {
+				t.Fatalf("checkTarget rejects the selected release: %v", err)
+			}
+		})
+	}
+}
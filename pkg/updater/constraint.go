@@ -0,0 +1,228 @@
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constraint is a version range, e.g. "~1.4", "^1.2.3", ">=1.2, <2",
+// "1.4.x" or "1.x || 2.0.x". Comparators in a group must all match; groups
+// are separated by "||".
+//
+// Partial versions are ranges: "1.4" and "1.4.x" mean >=1.4.0 <1.5.0-0,
+// "~1.4.2" means >=1.4.2 <1.5.0-0 and "^1.2" means >=1.2.0 <2.0.0-0 (on 0.x
+// the first non-zero part is fixed). Upper bounds exclude prereleases of
+// the next version.
+type Constraint struct {
+	raw    string
+	groups [][]bound
+}
+
+type bound struct {
+	op      string // "=", "!=", ">", ">=", "<", "<="
+	version string
+}
+
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+	for _, alt := range strings.Split(c.raw, "||") {
+		var group []bound
+		for _, tok := range splitComparators(alt) {
+			b, err := parseComparator(tok)
+			if err != nil {
+				return nil, fmt.Errorf("version constraint %q: %w", c.raw, err)
+			}
+			group = append(group, b...)
+		}
+		if len(group) == 0 && strings.TrimSpace(alt) == "" {
+			return nil, fmt.Errorf("version constraint %q: empty alternative", c.raw)
+		}
+		c.groups = append(c.groups, group)
+	}
+	return c, nil
+}
+
+func (c *Constraint) String() string { return c.raw }
+
+// Match reports whether v satisfies the constraint, ordering versions with
+// cmp (nil = LooseVersionComparer).
+func (c *Constraint) Match(v string, cmp VersionComparer) (bool, error) {
+	if cmp == nil {
+		cmp = LooseVersionComparer{}
+	}
+	for _, group := range c.groups {
+		ok := true
+		for _, b := range group {
+			r, err := cmp.Compare(v, b.version)
+			if err != nil {
+				return false, err
+			}
+			switch b.op {
+			case "=":
+				ok = r == 0
+			case "!=":
+				ok = r != 0
+			case ">":
+				ok = r > 0
+			case ">=":
+				ok = r >= 0
+			case "<":
+				ok = r < 0
+			case "<=":
+				ok = r <= 0
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitComparators splits on commas and spaces, keeping an operator
+// separated from its version ("> 1.2") together.
+func splitComparators(s string) []string {
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	var out []string
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.TrimLeft(f, "=!<>~^") == "" && i+1 < len(fields) {
+			f += fields[i+1]
+			i++
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func parseComparator(tok string) ([]bound, error) {
+	op := ""
+	for _, p := range []string{">=", "<=", "!=", "==", ">", "<", "=", "~>", "~", "^"} {
+		if strings.HasPrefix(tok, p) {
+			op, tok = p, tok[len(p):]
+			break
+		}
+	}
+	core, pre, n, err := parsePartial(tok)
+	if err != nil {
+		return nil, err
+	}
+	full := fmt.Sprintf("%d.%d.%d", core[0], core[1], core[2])
+	if pre != "" {
+		full += "-" + pre
+	}
+	if n < 3 && pre != "" {
+		return nil, fmt.Errorf("prerelease on partial version %q", tok)
+	}
+	// next returns the lowest version above the first i+1 parts, e.g.
+	// next(1) of 1.4.2 is 1.5.0-0
+	next := func(i int) string {
+		c := core
+		c[i]++
+		for j := i + 1; j < 3; j++ {
+			c[j] = 0
+		}
+		return fmt.Sprintf("%d.%d.%d-0", c[0], c[1], c[2])
+	}
+
+	switch op {
+	case "", "=", "==":
+		if n == 0 {
+			return nil, nil
+		}
+		if n == 3 {
+			return []bound{{"=", full}}, nil
+		}
+		return []bound{{">=", full}, {"<", next(n - 1)}}, nil
+	case "!=":
+		if n < 3 {
+			return nil, fmt.Errorf("!= needs a full version, got %q", tok)
+		}
+		return []bound{{"!=", full}}, nil
+	case ">":
+		if n == 0 {
+			return nil, fmt.Errorf("> needs a version")
+		}
+		if n == 3 {
+			return []bound{{">", full}}, nil
+		}
+		return []bound{{">=", next(n - 1)}}, nil
+	case ">=":
+		return []bound{{">=", full}}, nil
+	case "<":
+		return []bound{{"<", full}}, nil
+	case "<=":
+		if n == 3 {
+			return []bound{{"<=", full}}, nil
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return []bound{{"<", next(n - 1)}}, nil
+	case "~", "~>":
+		switch n {
+		case 0:
+			return nil, nil
+		case 1:
+			return []bound{{">=", full}, {"<", next(0)}}, nil
+		}
+		return []bound{{">=", full}, {"<", next(1)}}, nil
+	case "^":
+		switch {
+		case n == 0:
+			return nil, nil
+		case core[0] > 0 || n == 1:
+			return []bound{{">=", full}, {"<", next(0)}}, nil
+		case core[1] > 0 || n == 2:
+			return []bound{{">=", full}, {"<", next(1)}}, nil
+		}
+		return []bound{{">=", full}, {"<", next(2)}}, nil
+	}
+	return nil, fmt.Errorf("bad comparator %q", op+tok)
+}
+
+// parsePartial parses "1", "1.4", "v1.4.x" or "1.4.2-rc.1" into a padded
+// core and the number of parts given before any wildcard.
+func parsePartial(s string) (core [3]uint64, pre string, n int, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, pre = s[:i], s[i+1:]
+	}
+	if s == "" {
+		return core, "", 0, fmt.Errorf("missing version")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return core, "", 0, fmt.Errorf("bad version %q", s)
+	}
+	wild := false
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			wild = true
+			continue
+		}
+		if wild {
+			return core, "", 0, fmt.Errorf("bad version %q", s)
+		}
+		v, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return core, "", 0, fmt.Errorf("bad version %q", s)
+		}
+		core[i] = v
+		n = i + 1
+	}
+	if wild && pre != "" {
+		return core, "", 0, fmt.Errorf("prerelease on wildcard version %q", s)
+	}
+	return core, pre, n, nil
+}
@@ -0,0 +1,100 @@
+package updater
+
+import "testing"
+
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"1.4.2", []string{"1.4.2", "v1.4.2", "1.4.2+build.5"}, []string{"1.4.3", "1.4.2-rc.1"}},
+		{"=1.4.2", []string{"1.4.2"}, []string{"1.4.1"}},
+		{"== 1.4.2", []string{"1.4.2"}, []string{"1.4.1"}},
+		{"!=1.4.2", []string{"1.4.1", "1.4.3"}, []string{"1.4.2"}},
+		{"1.4", []string{"1.4.0", "1.4.99"}, []string{"1.3.9", "1.5.0", "1.5.0-rc.1", "1.4.0-rc.1"}},
+		{"1.4.x", []string{"1.4.0", "1.4.7"}, []string{"1.5.0", "1.3.0"}},
+		{"1.X", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.0"}},
+		{"*", []string{"0.0.1", "9.9.9", "1.0.0-rc.1"}, nil},
+		{"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0", "1.5.0-0"}},
+		{"~1.4", []string{"1.4.0", "1.4.9"}, []string{"1.5.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"~>1.4", []string{"1.4.5"}, []string{"1.5.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0", "2.0.0-rc.1"}},
+		{"^1.2", []string{"1.2.0", "1.99.0"}, []string{"1.1.9", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.0.2"}},
+		{"^0.0", []string{"0.0.0", "0.0.9"}, []string{"0.1.0"}},
+		{"^0", []string{"0.0.0", "0.9.9"}, []string{"1.0.0"}},
+		{">1.4.2", []string{"1.4.3", "2.0.0"}, []string{"1.4.2", "1.4.2-rc.1"}},
+		{">1.4", []string{"1.5.0", "1.5.0-rc.1"}, []string{"1.4.9"}},
+		{">=1.2, <2", []string{"1.2.0", "1.9.9", "2.0.0-rc.1"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2 < 2", []string{"1.5.0"}, []string{"2.1.0"}},
+		{"<=1.4", []string{"1.4.9", "1.0.0"}, []string{"1.5.0"}},
+		{"<=1.4.2", []string{"1.4.2"}, []string{"1.4.3"}},
+		{"<1.4.2", []string{"1.4.1", "1.4.2-rc.1"}, []string{"1.4.2"}},
+		{"1.x || 2.0.x", []string{"1.3.0", "2.0.5"}, []string{"2.1.0", "0.9.0"}},
+		{">=2.0.0-rc.1, <2.0.0", []string{"2.0.0-rc.1", "2.0.0-rc.2"}, []string{"2.0.0-beta.9", "2.0.0"}},
+		{"v1.4.2", []string{"1.4.2"}, nil},
+		{"1.4.2+build", []string{"1.4.2"}, nil},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		if c.String() != tt.constraint {
+			t.Errorf("String() = %q, want %q", c, tt.constraint)
+		}
+		for _, want := range []bool{true, false} {
+			versions := tt.match
+			if !want {
+				versions = tt.noMatch
+			}
+			for _, v := range versions {
+				got, err := c.Match(v, nil)
+				if err != nil || got != want {
+					t.Errorf("%q.Match(%q) = %v, %v; want %v", tt.constraint, v, got, err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"  ",
+		"1.4 ||",
+		"|| 1.4",
+		"1.2.3.4",
+		"1.x.3",
+		"1.4-rc.1",
+		"1.x-rc.1",
+		"!=1.4",
+		">",
+		">x",
+		"abc",
+		"1.-2.0",
+		"=>1.0.0",
+	} {
+		if c, err := ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q) = %v, want error", s, c.groups)
+		}
+	}
+}
+
+func TestConstraintMatchComparer(t *testing.T) {
+	c, err := ParseConstraint(">=1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the strict comparer rejects versions that are not SemVer
+	if _, err := c.Match("1.3", SemVerComparer{}); err == nil {
+		t.Error("SemVerComparer: want error for 1.3")
+	}
+	if ok, err := c.Match("1.3", LooseVersionComparer{}); err != nil || !ok {
+		t.Errorf("LooseVersionComparer: got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,96 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/delta"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// selectPatch returns a patch that applies to the installed binary and
+// whose result can be verified, or nil.
+func (u *Updater) selectPatch(m *Manifest, a *Artifact, osName, arch string) (*Patch, string) {
+	if len(m.Patches) == 0 {
+		return nil, ""
+	}
+	curSum, err := verify.FileSHA256Hex(u.currentPath())
+	if err != nil {
+		return nil, ""
+	}
+
+	for i := range m.Patches {
+		p := m.Patches[i]
+		if !strings.EqualFold(p.OS, osName) || !strings.EqualFold(p.Arch, arch) {
+			continue
+		}
+		if p.FromVersion != "" && u.cfg.CurrentVersion != "" {
+			if c, err := u.cfg.VersionComparer.Compare(p.FromVersion, u.cfg.CurrentVersion); err != nil || c != 0 {
+				continue
+			}
+		}
+		if normalizeSHA(p.FromSHA256) != curSum {
+			continue
+		}
+		// the patch output must be checkable against a known hash
+		target := p.ToSHA256
+		if target == "" && a.Format == "" {
+			target = a.SHA256
+		}
+		if target == "" {
+			continue
+		}
+		return &p, target
+	}
+	return nil, ""
+}
+
+// applyPatch downloads p and writes the patched binary to dst, verified
+// against target. Any error means the caller should fall back to the full
+// artifact.
+func (u *Updater) applyPatch(ctx context.Context, p *Patch, target, dst string) error {
+	format := strings.ToLower(p.Format)
+	if format != "" && format != "bsdiff" {
+		return fmt.Errorf("unsupported patch format %q", p.Format)
+	}
+
+	patchPath := dst + ".patch"
+	defer util.RemoveWithRetry(patchPath, 5, 100*time.Millisecond)
+
+	pa := &Artifact{URL: p.URL, SHA256: p.SHA256, Size: p.Size}
+	if _, err := u.fetchArtifact(ctx, pa, patchPath, 0); err != nil {
+		return err
+	}
+
+	old, err := os.ReadFile(u.currentPath())
+	if err != nil {
+		return err
+	}
+	patch, err := os.ReadFile(patchPath)
+	if err != nil {
+		return err
+	}
+	out, err := delta.ApplyBSDiff(old, patch)
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".patched"
+	if err := os.WriteFile(tmp, out, 0755); err != nil {
+		return err
+	}
+	if err := verify.VerifyFileSHA256(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("patched binary: %w", err)
+	}
+	return util.RenameWithRetry(tmp, dst, 30, 250*time.Millisecond)
+}
+
+func normalizeSHA(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.TrimPrefix(s, "sha256:")
+}
@@ -0,0 +1,123 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoRelease means an index lists no release for the configured channel,
+// version constraint or pinned version.
+var ErrNoRelease = errors.New("no matching release in index")
+
+// selectRelease picks the target from an index manifest: Config.PinVersion
+// exactly, else the highest release on a channel accepted as checkTarget
+// would (Config.Channel, empty = stable, or any with AllowChannelMismatch)
+// that matches Config.VersionConstraint. The result carries the index's
+// product, expiry and key id.
+func (u *Updater) selectRelease(idx *Manifest) (*Manifest, error) {
+	var want *Constraint
+	if u.cfg.PinVersion == "" && u.cfg.VersionConstraint != "" {
+		c, err := ParseConstraint(u.cfg.VersionConstraint)
+		if err != nil {
+			return nil, err
+		}
+		want = c
+	}
+
+	var best *Manifest
+	for i := range idx.Releases {
+		r := &idx.Releases[i]
+		if u.cfg.PinVersion != "" {
+			c, err := u.cfg.VersionComparer.Compare(r.Version, u.cfg.PinVersion)
+			if err != nil {
+				return nil, fmt.Errorf("compare versions: %w", err)
+			}
+			if c == 0 {
+				best = r
+				break
+			}
+			continue
+		}
+		if !u.acceptsRelease(idx, r) {
+			continue
+		}
+		if want != nil {
+			ok, err := want.Match(r.Version, u.cfg.VersionComparer)
+			if err != nil {
+				return nil, fmt.Errorf("compare versions: %w", err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if best != nil {
+			c, err := u.cfg.VersionComparer.Compare(r.Version, best.Version)
+			if err != nil {
+				return nil, fmt.Errorf("compare versions: %w", err)
+			}
+			if c <= 0 {
+				continue
+			}
+		}
+		best = r
+	}
+
+	if best == nil {
+		switch {
+		case u.cfg.PinVersion != "":
+			return nil, fmt.Errorf("%w: version %s", ErrNoRelease, u.cfg.PinVersion)
+		case want != nil:
+			return nil, fmt.Errorf("%w: channel %q, constraint %s", ErrNoRelease, u.channel(), want)
+		}
+		return nil, fmt.Errorf("%w: channel %q", ErrNoRelease, u.channel())
+	}
+
+	m := *best
+	m.Product = idx.Product
+	m.Channel = releaseChannel(idx, best)
+	m.ExpiresAt = idx.ExpiresAt
+	m.KeyID = idx.KeyID
+	m.Releases = nil
+	if m.PublishedAt.IsZero() {
+		m.PublishedAt = idx.PublishedAt
+	}
+	return &m, nil
+}
+
+// acceptsRelease reports whether r is on a channel this client may install,
+// the same rule checkTarget applies to a single manifest.
+func (u *Updater) acceptsRelease(idx, r *Manifest) bool {
+	return u.cfg.AllowChannelMismatch || u.channelAccepts(releaseChannel(idx, r))
+}
+
+// newestRelease returns the highest version an index lists on a channel
+// this client accepts ("" if none); an index is as new as that release for
+// anti-rollback purposes, so a nightly entry cannot hide a stable rollback.
+func (u *Updater) newestRelease(idx *Manifest) (string, error) {
+	v := ""
+	for i := range idx.Releases {
+		r := &idx.Releases[i]
+		if !u.acceptsRelease(idx, r) {
+			continue
+		}
+		if v == "" {
+			v = r.Version
+			continue
+		}
+		c, err := u.cfg.VersionComparer.Compare(r.Version, v)
+		if err != nil {
+			return "", fmt.Errorf("compare versions: %w", err)
+		}
+		if c > 0 {
+			v = r.Version
+		}
+	}
+	return v, nil
+}
+
+func releaseChannel(idx, r *Manifest) string {
+	if r.Channel != "" {
+		return r.Channel
+	}
+	return idx.Channel
+}
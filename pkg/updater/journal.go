@@ -0,0 +1,286 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/service"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+// journal operations
+const (
+	opUpdate   = "update"   // Update
+	opRollback = "rollback" // Rollback, RollbackTo
+	opRestore  = "restore"  // failed health checks
+)
+
+// journal is the write-ahead record of a binary swap, kept in
+// Config.JournalFile from before the Applier runs until the swap and its
+// bookkeeping are done. Each step is written before it is taken; Recover
+// uses it to finish or undo a swap that was interrupted.
+type journal struct {
+	Op            string    `json:"op"`
+	Step          string    `json:"step"`
+	FromVersion   string    `json:"from_version,omitempty"`
+	ToVersion     string    `json:"to_version,omitempty"`
+	BackupVersion string    `json:"backup_version,omitempty"`
+	Current       string    `json:"current"`
+	New           string    `json:"new"`
+	Old           string    `json:"old"`
+	NewSHA256     string    `json:"new_sha256"`
+	OldSHA256     string    `json:"old_sha256,omitempty"` // empty on first install
+	StartedAt     time.Time `json:"started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	path string
+}
+
+// beginSwap records the intent to swap newPath into place, moving the
+// current binary to oldPath.
+func (u *Updater) beginSwap(op, from, to, newPath, oldPath string) (*journal, error) {
+	j := &journal{Op: op, Step: "prepared", FromVersion: from, ToVersion: to,
+		Current: u.currentPath(), New: newPath, Old: oldPath, StartedAt: time.Now().UTC(), path: u.cfg.JournalFile}
+	var err error
+	if j.NewSHA256, err = verify.FileSHA256Hex(newPath); err != nil {
+		return nil, err
+	}
+	if j.OldSHA256, err = fileSHA256(j.Current); err != nil {
+		return nil, err
+	}
+	if err := j.write(); err != nil {
+		return nil, fmt.Errorf("write journal: %w", err)
+	}
+	return j, nil
+}
+
+func (j *journal) write() error {
+	j.UpdatedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileSync(j.path, b, 0644)
+}
+
+func (j *journal) step(step string) error {
+	j.Step = step
+	return j.write()
+}
+
+func (j *journal) done() error {
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// archiveJournal sets the journal aside as <path>.stale, replacing an older one.
+func archiveJournal(path string) (string, error) {
+	stale := path + ".stale"
+	if err := os.Rename(path, stale); err != nil {
+		return "", err
+	}
+	return stale, nil
+}
+
+func loadJournal(path string) (*journal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{path: path}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("decode journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// swap runs the Applier for j, journaling each step it reports. If Apply
+// fails but the previous binary is back in place the journal is dropped,
+// otherwise it is left for Recover.
+func (u *Updater) swap(ctx context.Context, j *journal) (string, error) {
+	if u.cfg.Applier == nil {
+		return "", fmt.Errorf("Applier is nil")
+	}
+	sctx := apply.WithStepFunc(ctx, func(step string) {
+		if err := j.step(step); err != nil {
+			u.logf("journal: %v", err)
+		}
+	})
+	oldBackup, err := u.cfg.Applier.Apply(sctx, u.cfg.Service, j.Current, j.New, j.Old)
+	if err != nil {
+		if sum, serr := fileSHA256(j.Current); serr == nil && sum == j.OldSHA256 {
+			_ = j.done()
+		}
+		return "", err
+	}
+	if err := j.step("applied"); err != nil {
+		u.logf("journal: %v", err)
+	}
+	return oldBackup, nil
+}
+
+// errReplaced means the current binary is neither side of the swap.
+var errReplaced = errors.New("matches neither the new nor the previous binary")
+
+// RecoverResult describes an interrupted swap found by Recover. Completed
+// reports whether the swap was finished (ToVersion installed) or undone;
+// Discarded that the binary had been replaced outside the updater and the
+// journal was set aside without touching it.
+type RecoverResult struct {
+	Op          string
+	Step        string
+	FromVersion string
+	ToVersion   string
+	Completed   bool
+	Discarded   bool
+}
+
+// Recover finishes or undoes a swap interrupted by a crash or power loss,
+// e.g. one that left only <exe>.old and <exe>.new on disk. It returns nil
+// when the journal is empty. The outcome only depends on the files found:
+//
+//   - the current binary is the new one: the swap is completed (for an
+//     update, health checks run again and may roll it back)
+//   - the current binary is the previous one: the swap is abandoned
+//   - no current binary: the new one is moved into place if intact,
+//     otherwise the previous one
+//   - any other current binary was installed by someone else: it is kept
+//     and the journal is set aside as <JournalFile>.stale
+//
+// The service is started afterwards if it is not running. A journal that
+// cannot be resolved keeps failing; DiscardJournal drops it.
+func (u *Updater) Recover(ctx context.Context) (*RecoverResult, error) {
+	j, err := loadJournal(u.cfg.JournalFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.logf("recovering interrupted %s %s -> %s (step %s)", j.Op, orUnknown(j.FromVersion), orUnknown(j.ToVersion), j.Step)
+	res := &RecoverResult{Op: j.Op, Step: j.Step, FromVersion: j.FromVersion, ToVersion: j.ToVersion}
+
+	res.Completed, err = u.resolveSwap(j)
+	if errors.Is(err, errReplaced) {
+		res.Discarded = true
+		stale, aerr := archiveJournal(j.path)
+		if aerr != nil {
+			return nil, fmt.Errorf("recover: %w", aerr)
+		}
+		u.logf("warning: %v; assuming it was replaced outside the updater, journal kept as %s", err, stale)
+	} else if err != nil {
+		return nil, fmt.Errorf("recover: %w", err)
+	}
+	if err := u.ensureStarted(ctx); err != nil {
+		return res, fmt.Errorf("recover: start service: %w", err)
+	}
+	if res.Discarded {
+		return res, nil
+	}
+	if !res.Completed {
+		u.logf("recovered: kept %s", orUnknown(j.FromVersion))
+		return res, j.done()
+	}
+
+	switch j.Op {
+	case opUpdate:
+		if len(u.cfg.HealthChecks) > 0 {
+			if err := u.checkHealth(ctx); err != nil {
+				res.Completed = false
+				return res, u.rollbackUnhealthy(ctx, j.ToVersion, j.Old, err)
+			}
+		}
+		if err := u.keepBackup(j.Old, j.FromVersion); err != nil {
+			u.logf("keep backup: %v", err)
+		}
+		err = u.recordInstall(j.ToVersion, j.FromVersion)
+	case opRollback:
+		err = u.recordRollback(j.FromVersion, j.ToVersion, j.BackupVersion)
+	case opRestore:
+		err = u.recordRestore(j.FromVersion)
+	}
+	if err != nil {
+		u.logf("record %s: %v", j.Op, err)
+	}
+	u.logf("recovered: completed %s to %s", j.Op, orUnknown(j.ToVersion))
+	return res, j.done()
+}
+
+// resolveSwap leaves the binary j was swapping in, or the one it replaced,
+// at j.Current and reports which.
+func (u *Updater) resolveSwap(j *journal) (completed bool, err error) {
+	cur, err := fileSHA256(j.Current)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case cur == j.NewSHA256:
+		return true, nil
+	case cur != "" && cur == j.OldSHA256:
+		return false, nil
+	case cur != "":
+		return false, fmt.Errorf("%s %w", j.Current, errReplaced)
+	}
+
+	if sum, err := fileSHA256(j.New); err == nil && sum == j.NewSHA256 {
+		u.logf("moving %s into place", j.New)
+		return true, moveInPlace(j.New, j.Current)
+	}
+	if sum, err := fileSHA256(j.Old); err == nil && j.OldSHA256 != "" && sum == j.OldSHA256 {
+		u.logf("moving %s back into place", j.Old)
+		return false, moveInPlace(j.Old, j.Current)
+	}
+	return false, fmt.Errorf("no intact binary for %s", j.Current)
+}
+
+// DiscardJournal sets an interrupted swap's journal aside as
+// <JournalFile>.stale without touching any binary, for a journal Recover
+// cannot resolve (e.g. neither binary is intact). It returns the new path,
+// or "" when there was no journal.
+func (u *Updater) DiscardJournal() (string, error) {
+	stale, err := archiveJournal(u.cfg.JournalFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	u.logf("discarded swap journal, kept as %s", stale)
+	return stale, nil
+}
+
+func moveInPlace(from, to string) error {
+	if err := util.RenameWithRetry(from, to, 5, 200*time.Millisecond); err != nil {
+		return err
+	}
+	util.SyncDir(filepath.Dir(to))
+	return nil
+}
+
+// ensureStarted starts the service unless it reports itself running.
+func (u *Updater) ensureStarted(ctx context.Context) error {
+	if sc, ok := u.cfg.Service.(service.StatusController); ok {
+		if active, err := sc.Active(ctx); err == nil && active {
+			return nil
+		}
+	}
+	return u.cfg.Service.Start(ctx)
+}
+
+// fileSHA256 is the file's sha256, or "" if it does not exist.
+func fileSHA256(path string) (string, error) {
+	sum, err := verify.FileSHA256Hex(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return sum, err
+}
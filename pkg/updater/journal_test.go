@@ -0,0 +1,305 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/health"
+)
+
+// countingService counts starts; it cannot report its status, so Recover
+// always starts it.
+type countingService struct{ starts int }
+
+func (s *countingService) Stop(context.Context) error    { return nil }
+func (s *countingService) Start(context.Context) error   { s.starts++; return nil }
+func (s *countingService) Restart(context.Context) error { return nil }
+func (s *countingService) String() string                { return "counting" }
+
+type failingCheck struct{}
+
+func (failingCheck) Check(context.Context) error { return errors.New("not healthy") }
+func (failingCheck) String() string              { return "failing" }
+
+const (
+	prevBin = "previous binary"
+	nextBin = "next binary"
+)
+
+func TestRecover(t *testing.T) {
+	rename := func(from, to string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) {
+			if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write := func(name, body string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	remove := func(name string) func(*testing.T, string) {
+		return func(t *testing.T, dir string) { _ = os.Remove(filepath.Join(dir, name)) }
+	}
+
+	tests := []struct {
+		name     string
+		op       string // opUpdate: agent.new -> agent; opRollback: agent.old -> agent
+		step     string
+		crash    []func(*testing.T, string) // files as the crash left them
+		unhealth bool
+
+		wantErr       error // nil, ErrRolledBack or errAny
+		wantCurrent   string
+		wantCompleted bool
+		wantDiscarded bool
+		wantJournal   bool // still there for another attempt
+		wantInstalled string
+		wantBackup    string
+	}{
+		{
+			name:        "crashed before any rename",
+			op:          opUpdate,
+			step:        apply.StepStop,
+			wantCurrent: prevBin,
+		},
+		{
+			name:          "crashed between the renames",
+			op:            opUpdate,
+			step:          apply.StepMoveNew,
+			crash:         []func(*testing.T, string){rename("agent", "agent.old")},
+			wantCurrent:   nextBin,
+			wantCompleted: true,
+			wantInstalled: "2.0.0",
+			wantBackup:    "1.0.0",
+		},
+		{
+			name:        "partial rename with a corrupt new binary",
+			op:          opUpdate,
+			step:        apply.StepMoveNew,
+			crash:       []func(*testing.T, string){rename("agent", "agent.old"), write("agent.new", nextBin[:4])},
+			wantCurrent: prevBin,
+		},
+		{
+			name:          "crashed before starting the service",
+			op:            opUpdate,
+			step:          apply.StepStart,
+			crash:         []func(*testing.T, string){rename("agent", "agent.old"), rename("agent.new", "agent")},
+			wantCurrent:   nextBin,
+			wantCompleted: true,
+			wantInstalled: "2.0.0",
+			wantBackup:    "1.0.0",
+		},
+		{
+			name:          "swap applied, bookkeeping lost",
+			op:            opUpdate,
+			step:          "applied",
+			crash:         []func(*testing.T, string){rename("agent", "agent.old"), rename("agent.new", "agent")},
+			wantCurrent:   nextBin,
+			wantCompleted: true,
+			wantInstalled: "2.0.0",
+			wantBackup:    "1.0.0",
+		},
+		{
+			name:          "health checks fail after completing",
+			op:            opUpdate,
+			step:          apply.StepStart,
+			crash:         []func(*testing.T, string){rename("agent", "agent.old"), rename("agent.new", "agent")},
+			unhealth:      true,
+			wantErr:       ErrRolledBack,
+			wantCurrent:   prevBin,
+			wantInstalled: "1.0.0",
+		},
+		{
+			name:          "binary replaced outside the updater",
+			op:            opUpdate,
+			step:          apply.StepMoveNew,
+			crash:         []func(*testing.T, string){write("agent", "hand-installed binary")},
+			wantCurrent:   "hand-installed binary",
+			wantDiscarded: true,
+		},
+		{
+			name:        "no intact binary",
+			op:          opUpdate,
+			step:        apply.StepMoveNew,
+			crash:       []func(*testing.T, string){remove("agent"), write("agent.new", "garbage")},
+			wantErr:     errAny,
+			wantJournal: true,
+		},
+		{
+			name:          "rollback crashed between the renames",
+			op:            opRollback,
+			step:          apply.StepMoveNew,
+			crash:         []func(*testing.T, string){rename("agent", "agent.new")},
+			wantCurrent:   nextBin,
+			wantCompleted: true,
+			wantInstalled: "0.9.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := install(t, map[string]string{"agent": prevBin})
+			svc := &countingService{}
+			cfg := Config{InstallDir: dir, ExeName: "agent", Applier: renameApplier{}, Service: svc, BackupKeep: -1}
+			if tt.unhealth {
+				cfg.HealthChecks = []health.Check{failingCheck{}}
+				cfg.HealthTimeout = 50 * time.Millisecond
+				cfg.HealthInterval = 10 * time.Millisecond
+			}
+			u := New(cfg)
+			if err := u.recordInstall("1.0.0", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			// journal the swap as Update or Rollback would, then crash
+			in, out, to := "agent.new", "agent.old", "2.0.0"
+			if tt.op == opRollback {
+				in, out, to = "agent.old", "agent.new", "0.9.0"
+			}
+			write(in, nextBin)(t, dir)
+			j, err := u.beginSwap(tt.op, "1.0.0", to, filepath.Join(dir, in), filepath.Join(dir, out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := j.step(tt.step); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.crash {
+				f(t, dir)
+			}
+
+			// a fresh process
+			u = New(cfg)
+			res, err := u.Recover(context.Background())
+			if !matchErr(err, tt.wantErr) {
+				t.Fatalf("Recover error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil || errors.Is(err, ErrRolledBack) {
+				if res == nil || res.Op != tt.op || res.Step != tt.step || res.Completed != tt.wantCompleted || res.Discarded != tt.wantDiscarded {
+					t.Fatalf("result = %+v", res)
+				}
+				if svc.starts == 0 {
+					t.Error("service not started")
+				}
+			}
+
+			if got := readFile(t, filepath.Join(dir, "agent")); got != tt.wantCurrent {
+				t.Errorf("current binary = %q, want %q", got, tt.wantCurrent)
+			}
+			_, statErr := os.Stat(filepath.Join(cfg.InstallDir, "agent.journal.json"))
+			if hasJournal := statErr == nil; hasJournal != tt.wantJournal {
+				t.Errorf("journal present = %v, want %v", hasJournal, tt.wantJournal)
+			}
+			_, statErr = os.Stat(filepath.Join(cfg.InstallDir, "agent.journal.json.stale"))
+			if stale := statErr == nil; stale != tt.wantDiscarded {
+				t.Errorf("stale journal present = %v, want %v", stale, tt.wantDiscarded)
+			}
+
+			st, err := loadState(u.cfg.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantInstalled := tt.wantInstalled
+			if wantInstalled == "" {
+				wantInstalled = "1.0.0"
+			}
+			if st.InstalledVersion != wantInstalled || st.BackupVersion != tt.wantBackup {
+				t.Errorf("state = %s (backup %q), want %s (backup %q)", st.InstalledVersion, st.BackupVersion, wantInstalled, tt.wantBackup)
+			}
+
+			// the next run has nothing left to do, or is told to drop the journal
+			if tt.wantJournal {
+				if stale, err := u.DiscardJournal(); err != nil || stale == "" {
+					t.Fatalf("DiscardJournal = %q, %v", stale, err)
+				}
+			}
+			if res, err := u.Recover(context.Background()); res != nil || err != nil {
+				t.Fatalf("second Recover = %+v, %v", res, err)
+			}
+		})
+	}
+}
+
+func TestSwapJournalsSteps(t *testing.T) {
+	dir := install(t, map[string]string{"agent": prevBin, "agent.new": nextBin})
+	cfg := Config{InstallDir: dir, ExeName: "agent", Applier: renameApplier{crashAt: apply.StepStart}, Service: &countingService{}, BackupKeep: -1}
+	u := New(cfg)
+
+	j, err := u.beginSwap(opUpdate, "1.0.0", "2.0.0", filepath.Join(dir, "agent.new"), filepath.Join(dir, "agent.old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.swap(context.Background(), j); !errors.Is(err, errCrash) {
+		t.Fatalf("swap = %v, want the simulated crash", err)
+	}
+	j, err = loadJournal(filepath.Join(cfg.InstallDir, "agent.journal.json"))
+	if err != nil {
+		t.Fatalf("journal not kept after a crash mid-swap: %v", err)
+	}
+	if j.Step != apply.StepMoveNew || j.NewSHA256 == "" || j.OldSHA256 == "" {
+		t.Fatalf("journal = %+v, want step %s with both hashes", j, apply.StepMoveNew)
+	}
+
+	res, err := New(cfg).Recover(context.Background())
+	if err != nil || !res.Completed {
+		t.Fatalf("Recover = %+v, %v", res, err)
+	}
+}
+
+func TestSwapFailureWithPreviousBinaryInPlace(t *testing.T) {
+	// nothing was moved, so there is nothing to recover
+	dir := install(t, map[string]string{"agent": prevBin, "agent.new": nextBin})
+	cfg := Config{InstallDir: dir, ExeName: "agent", Applier: renameApplier{crashAt: apply.StepMoveCurrent}, BackupKeep: -1}
+	u := New(cfg)
+	j, err := u.beginSwap(opUpdate, "1.0.0", "2.0.0", filepath.Join(dir, "agent.new"), filepath.Join(dir, "agent.old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.swap(context.Background(), j); !errors.Is(err, errCrash) {
+		t.Fatalf("swap = %v", err)
+	}
+	if res, err := u.Recover(context.Background()); res != nil || err != nil {
+		t.Fatalf("Recover = %+v, %v; want no journal", res, err)
+	}
+}
+
+func TestDiscardJournalWithoutJournal(t *testing.T) {
+	u := New(Config{InstallDir: t.TempDir(), ExeName: "agent"})
+	if stale, err := u.DiscardJournal(); stale != "" || err != nil {
+		t.Fatalf("DiscardJournal = %q, %v", stale, err)
+	}
+}
+
+func TestRecoverCorruptJournal(t *testing.T) {
+	dir := install(t, map[string]string{"agent": prevBin, "agent.journal.json": "{not json"})
+	u := New(Config{InstallDir: dir, ExeName: "agent"})
+	if _, err := u.Recover(context.Background()); err == nil {
+		t.Fatal("want error for a corrupt journal")
+	}
+	if _, err := u.DiscardJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := u.Recover(context.Background()); res != nil || err != nil {
+		t.Fatalf("Recover after discard = %+v, %v", res, err)
+	}
+}
+
+var errAny = errors.New("any error")
+
+func matchErr(err, want error) bool {
+	switch want {
+	case nil:
+		return err == nil
+	case errAny:
+		return err != nil
+	}
+	return errors.Is(err, want)
+}
@@ -0,0 +1,195 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+type MirrorStrategy int
+
+const (
+	// MirrorsInOrder tries Artifact.URL first, then mirrors by ascending
+	// priority; equal priorities are shuffled by weight.
+	MirrorsInOrder MirrorStrategy = iota
+	// MirrorsByLatency probes every URL with HEAD and tries the fastest first.
+	MirrorsByLatency
+)
+
+// fetchArtifact downloads a to dst from the first URL that yields a file
+// with the expected SHA256 and returns that URL.
+func (u *Updater) fetchArtifact(ctx context.Context, a *Artifact, dst string, minBytes int64) (string, error) {
+	dl := util.Downloader{
+		UserAgent:    u.cfg.UserAgent,
+		MinBytes:     minBytes,
+		Client:       u.cfg.HTTPClient,
+		ExpectedSize: a.Size,
+		Retry:        u.retryPolicy(),
+		Progress:     u.cfg.OnProgress,
+	}
+
+	var errs []error
+	for _, url := range u.mirrorOrder(ctx, a) {
+		u.logf("downloading: %s", url)
+		if err := dl.Download(ctx, url, dst); err != nil {
+			u.logf("download from %s failed: %v", url, err)
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		u.logf("downloaded to: %s", dst)
+
+		// a bad mirror must not win
+		if err := u.verifyDownload(ctx, a, dst); err != nil {
+			u.logf("%s: %v", url, err)
+			_ = util.RemoveWithRetry(dst, 5, 100*time.Millisecond)
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+		return url, nil
+	}
+	if len(errs) == 0 {
+		return "", errors.New("artifact has no url")
+	}
+	if len(errs) == 1 {
+		return "", errors.Unwrap(errs[0])
+	}
+	return "", fmt.Errorf("all mirrors failed: %w", errors.Join(errs...))
+}
+
+// verifyDownload checks the SHA256 or, for artifacts published without one
+// (e.g. from a Sparkle appcast), the Ed25519 signature.
+func (u *Updater) verifyDownload(ctx context.Context, a *Artifact, path string) error {
+	if strings.TrimSpace(a.SHA256) == "" && u.cfg.ArtifactVerifier != nil && (a.Signature != "" || a.SignatureURL != "") {
+		return u.verifyArtifactSignature(ctx, a, path)
+	}
+	if err := verify.VerifyFileSHA256(path, a.SHA256); err != nil {
+		return err
+	}
+	u.logf("sha256 verified")
+	return nil
+}
+
+func (u *Updater) mirrorOrder(ctx context.Context, a *Artifact) []string {
+	ms := append([]Mirror(nil), a.Mirrors...)
+	weightedShuffle(ms)
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].Priority < ms[j].Priority })
+
+	var urls []string
+	seen := map[string]bool{}
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	add(a.URL)
+	for _, m := range ms {
+		add(m.URL)
+	}
+
+	if u.cfg.MirrorStrategy == MirrorsByLatency && len(urls) > 1 {
+		urls = u.sortByLatency(ctx, urls)
+	}
+	return urls
+}
+
+// weightedShuffle orders ms randomly, favoring higher weights (default 1).
+func weightedShuffle(ms []Mirror) {
+	key := make(map[int]float64, len(ms))
+	for i := range ms {
+		w := float64(ms[i].Weight)
+		if w <= 0 {
+			w = 1
+		}
+		// Efraimidis-Spirakis: sort by u^(1/w) descending
+		key[i] = -math.Pow(rand.Float64(), 1/w)
+	}
+	idx := make([]int, len(ms))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return key[idx[i]] < key[idx[j]] })
+	out := make([]Mirror, len(ms))
+	for i, k := range idx {
+		out[i] = ms[k]
+	}
+	copy(ms, out)
+}
+
+func (u *Updater) sortByLatency(ctx context.Context, urls []string) []string {
+	rtt := make([]time.Duration, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			rtt[i] = u.probe(ctx, url)
+		}(i, url)
+	}
+	wg.Wait()
+
+	idx := make([]int, len(urls))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return rtt[idx[i]] < rtt[idx[j]] })
+	out := make([]string, len(urls))
+	for i, k := range idx {
+		out[i] = urls[k]
+		u.logf("mirror %s: %s", urls[k], fmtRTT(rtt[k]))
+	}
+	return out
+}
+
+const unreachable = time.Duration(1<<63 - 1)
+
+func (u *Updater) probe(ctx context.Context, url string) time.Duration {
+	if path, ok := util.LocalPath(url); ok {
+		if _, err := os.Stat(path); err != nil {
+			return unreachable
+		}
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return unreachable
+	}
+	req.Header.Set("User-Agent", u.cfg.UserAgent)
+	client := u.cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return unreachable
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusNotFound {
+		return unreachable
+	}
+	return time.Since(start)
+}
+
+func fmtRTT(d time.Duration) string {
+	if d == unreachable {
+		return "unreachable"
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,149 @@
+package updater
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+func TestUpdateMirrorFailover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good/agent", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(nextBin)) })
+	mux.HandleFunc("/tampered/agent", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("tampered binary")) })
+	mux.HandleFunc("/down/agent", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	at := func(name string) string { return srv.URL + "/" + name + "/agent" }
+
+	tests := []struct {
+		name       string
+		url        string
+		mirrors    []Mirror
+		wantMirror string // "" = Update fails
+	}{
+		{"primary", at("good"), []Mirror{{URL: at("tampered")}}, at("good")},
+		{"bad hash fails over", at("tampered"), []Mirror{{URL: at("good")}}, at("good")},
+		{"priority decides", at("down"), []Mirror{{URL: at("good"), Priority: 2}, {URL: at("tampered"), Priority: 1}}, at("good")},
+		{"no url, mirrors only", "", []Mirror{{URL: at("down")}, {URL: at("good"), Priority: 1}}, at("good")},
+		{"all mirrors bad", at("tampered"), []Mirror{{URL: at("down")}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := install(t, map[string]string{"agent": prevBin})
+			ap := &countingApplier{}
+			u := New(Config{
+				CurrentVersion: "1.0.0",
+				InstallDir:     dir,
+				ExeName:        "agent",
+				Source:         staticSource{manifestFor(Artifact{URL: tt.url, Mirrors: tt.mirrors, SHA256: sha256Hex(nextBin)})},
+				Applier:        ap,
+				MinBytes:       1,
+				Retry:          &util.RetryPolicy{MaxAttempts: 1},
+				BackupKeep:     -1,
+			})
+			res, err := u.Update(context.Background())
+			if tt.wantMirror == "" {
+				if err == nil || ap.applies != 0 {
+					t.Fatalf("got %+v, %v after %d applies; want an error before apply", res, err, ap.applies)
+				}
+				if got := readFile(t, filepath.Join(dir, "agent")); got != prevBin {
+					t.Fatalf("current binary = %q, want it untouched", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.MirrorURL != tt.wantMirror {
+				t.Errorf("MirrorURL = %s, want %s", res.MirrorURL, tt.wantMirror)
+			}
+			if got := readFile(t, filepath.Join(dir, "agent")); got != nextBin {
+				t.Fatalf("current binary = %q, want the verified download", got)
+			}
+		})
+	}
+}
+
+func TestMirrorOrder(t *testing.T) {
+	a := &Artifact{
+		URL: "https://primary/agent",
+		Mirrors: []Mirror{
+			{URL: "https://c/agent", Priority: 2},
+			{URL: "https://a1/agent"},
+			{URL: "https://b/agent", Priority: 1},
+			{URL: "https://a2/agent", Weight: 5},
+			{URL: "https://primary/agent", Priority: 1}, // duplicate
+		},
+	}
+	u := New(Config{InstallDir: t.TempDir(), ExeName: "agent"})
+	for i := 0; i < 50; i++ {
+		got := u.mirrorOrder(context.Background(), a)
+		if len(got) != 5 || got[0] != "https://primary/agent" || got[3] != "https://b/agent" || got[4] != "https://c/agent" {
+			t.Fatalf("order = %v, want the url, priority 0 (shuffled), 1, 2", got)
+		}
+		if rest := map[string]bool{got[1]: true, got[2]: true}; !rest["https://a1/agent"] || !rest["https://a2/agent"] {
+			t.Fatalf("order = %v, want both priority 0 mirrors second", got)
+		}
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	const runs = 4000
+	heavyFirst := 0
+	for i := 0; i < runs; i++ {
+		ms := []Mirror{{URL: "light", Weight: 1}, {URL: "heavy", Weight: 9}}
+		weightedShuffle(ms)
+		if ms[0].URL == "heavy" {
+			heavyFirst++
+		}
+	}
+	// weight 9 of 10 goes first 90% of the time
+	if frac := float64(heavyFirst) / runs; frac < 0.85 || frac > 0.95 {
+		t.Fatalf("heavy mirror first in %.1f%% of runs, want about 90%%", frac*100)
+	}
+
+	// zero and negative weights count as 1 and nothing is lost
+	ms := []Mirror{{URL: "a"}, {URL: "b", Weight: -3}, {URL: "c", Weight: 2}}
+	weightedShuffle(ms)
+	seen := map[string]bool{}
+	for _, m := range ms {
+		seen[m.URL] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("shuffle lost mirrors: %v", ms)
+	}
+}
+
+func TestSortByLatency(t *testing.T) {
+	handler := func(delay time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(delay) })
+	}
+	slow := httptest.NewServer(handler(150 * time.Millisecond))
+	defer slow.Close()
+	fast := httptest.NewServer(handler(0))
+	defer fast.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	gone := httptest.NewServer(handler(0))
+	gone.Close()
+
+	a := &Artifact{
+		URL:     gone.URL,
+		Mirrors: []Mirror{{URL: slow.URL}, {URL: missing.URL}, {URL: fast.URL, Priority: 9}},
+	}
+	u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", MirrorStrategy: MirrorsByLatency})
+	got := u.mirrorOrder(context.Background(), a)
+	if want := []string{fast.URL, slow.URL}; !reflect.DeepEqual(got[:2], want) {
+		t.Fatalf("order = %v, want %v first", got, want)
+	}
+	// unreachable ones keep their relative order at the end
+	if want := []string{gone.URL, missing.URL}; !reflect.DeepEqual(got[2:], want) {
+		t.Fatalf("order = %v, want %v last", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build darwin
+
+package updater
+
+import "syscall"
+
+func hostOSVersion() string {
+	v, err := syscall.Sysctl("kern.osproductversion")
+	if err != nil {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,5 @@
+//go:build !darwin
+
+package updater
+
+func hostOSVersion() string { return "" }
@@ -0,0 +1,210 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/blitzh/go-autoupdater/pkg/health"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+)
+
+var (
+	// ErrRolledBack matches a *RollbackError whose restore succeeded.
+	ErrRolledBack = errors.New("update rolled back")
+	ErrNoBackup   = errors.New("no backup to roll back to")
+)
+
+// RollbackResult describes a Rollback. FromVersion and ToVersion are empty
+// when unknown (no CurrentVersion was set when updating).
+type RollbackResult struct {
+	FromVersion string
+	ToVersion   string
+	Path        string
+}
+
+// Rollback swaps the backup of the last update (<exe>.old) back into place
+// through the Applier, which stops and restarts the service. The replaced
+// version is recorded so Check does not offer it again.
+func (u *Updater) Rollback(ctx context.Context) (*RollbackResult, error) {
+	_, backup := u.stagingPaths()
+	if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoBackup
+	} else if err != nil {
+		return nil, err
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	res := &RollbackResult{FromVersion: st.InstalledVersion, ToVersion: st.BackupVersion, Path: u.currentPath()}
+	if res.FromVersion == "" {
+		res.FromVersion = u.cfg.CurrentVersion
+	}
+
+	u.logf("rolling back %s -> %s", res.FromVersion, res.ToVersion)
+	j, err := u.restore(ctx, backup, opRollback, res.FromVersion, res.ToVersion)
+	if err != nil {
+		return nil, fmt.Errorf("rollback: %w", err)
+	}
+
+	if err := u.recordRollback(res.FromVersion, res.ToVersion, ""); err != nil {
+		return res, fmt.Errorf("record rollback: %w", err)
+	}
+	_ = j.done()
+	u.logf("rolled back to %s", res.ToVersion)
+	return res, nil
+}
+
+// recordRollback marks from as rolled back and to as installed, with
+// backup now held in <exe>.old. The rollback was asked for, so the
+// anti-rollback floor drops to to (none if unknown) and the publisher can
+// pull from.
+func (u *Updater) recordRollback(from, to, backup string) error {
+	if from != "" {
+		if err := u.markRolledBack(from); err != nil {
+			return err
+		}
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	st.InstalledVersion, st.BackupVersion = to, backup
+	st.InstalledAt = time.Now().UTC()
+	st.HighestVersion = to
+	return st.save(u.cfg.StateFile)
+}
+
+// recordInstall remembers the versions of the new binary and its backup
+// for Rollback, and raises the anti-rollback floor to version.
+func (u *Updater) recordInstall(version, backup string) error {
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	st.InstalledVersion, st.BackupVersion = version, backup
+	st.InstalledAt = time.Now().UTC()
+	u.raiseFloor(st, version)
+	return st.save(u.cfg.StateFile)
+}
+
+// RollbackError is returned by Update when the new version failed its
+// health checks. The previous binary was restored unless RestoreErr is set.
+type RollbackError struct {
+	Version    string // the version that was rolled back
+	Cause      error
+	RestoreErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RestoreErr != nil {
+		return fmt.Sprintf("update to %s failed (%v) and restoring the previous version failed: %v", e.Version, e.Cause, e.RestoreErr)
+	}
+	return fmt.Sprintf("update to %s rolled back: %v", e.Version, e.Cause)
+}
+
+func (e *RollbackError) Unwrap() []error {
+	if e.RestoreErr != nil {
+		return []error{e.Cause, e.RestoreErr}
+	}
+	return []error{ErrRolledBack, e.Cause}
+}
+
+// checkHealth runs Config.HealthChecks within Config.HealthTimeout.
+func (u *Updater) checkHealth(ctx context.Context) error {
+	timeout := u.cfg.HealthTimeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	hctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return health.Run(hctx, u.cfg.HealthInterval, u.cfg.HealthChecks...)
+}
+
+// rollbackUnhealthy restores backup after version failed its health checks
+// and remembers version so it is not installed again.
+func (u *Updater) rollbackUnhealthy(ctx context.Context, version, backup string, cause error) error {
+	u.logf("health check failed, rolling back %s: %v", version, cause)
+	rerr := &RollbackError{Version: version, Cause: cause}
+
+	// restore even when the update's context has run out
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Minute)
+	defer cancel()
+	j, err := u.restore(rctx, backup, opRestore, version, "")
+	if err != nil {
+		u.logf("restore failed: %v", err)
+		rerr.RestoreErr = err
+		return rerr
+	}
+	if err := u.recordRestore(version); err != nil {
+		u.logf("record rollback: %v", err)
+	}
+	_ = j.done()
+	u.logf("rolled back to %s", backup)
+	return rerr
+}
+
+// restore swaps backup into place through the Applier (stop, swap, start);
+// the replaced binary is discarded. The caller finishes the journal once
+// the swap is recorded.
+func (u *Updater) restore(ctx context.Context, backup, op, from, to string) (*journal, error) {
+	if u.cfg.Applier == nil {
+		return nil, fmt.Errorf("Applier is nil")
+	}
+	discard, _ := u.stagingPaths()
+	j, err := u.beginSwap(op, from, to, backup, discard)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := u.swap(ctx, j); err != nil {
+		return nil, err
+	}
+	_ = util.RemoveWithRetry(discard, 5, 100*time.Millisecond)
+	return j, nil
+}
+
+// markRolledBack records version in the state file; Check no longer offers
+// it (an explicit PinVersion still does).
+func (u *Updater) markRolledBack(version string) error {
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	for _, v := range st.RolledBack {
+		if v == version {
+			return nil
+		}
+	}
+	st.RolledBack = append(st.RolledBack, version)
+	return st.save(u.cfg.StateFile)
+}
+
+// recordRestore marks version as rolled back once the backup replaced it;
+// <exe>.old is gone, so no backup version is left to roll back to.
+func (u *Updater) recordRestore(version string) error {
+	if err := u.markRolledBack(version); err != nil {
+		return err
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	st.BackupVersion = ""
+	return st.save(u.cfg.StateFile)
+}
+
+func (u *Updater) rolledBack(version string) bool {
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return false
+	}
+	for _, v := range st.RolledBack {
+		if c, err := u.cfg.VersionComparer.Compare(v, version); err == nil && c == 0 {
+			return true
+		}
+	}
+	return false
+}
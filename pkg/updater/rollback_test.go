@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/service"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// renameApplier swaps files like apply.PosixApplier, without retries or
+// build tags. With crashAt set it stops dead before that step, leaving the
+// files as a killed process would.
+type renameApplier struct {
+	crashAt string
+}
+
+func (a renameApplier) Apply(ctx context.Context, svc service.Controller, currentPath, newPath, oldPath string) (string, error) {
+	steps := []struct {
+		name string
+		do   func() error
+	}{
+		{apply.StepStop, func() error { return svc.Stop(ctx) }},
+		{apply.StepMoveCurrent, func() error {
+			_ = os.Remove(oldPath)
+			return os.Rename(currentPath, oldPath)
+		}},
+		{apply.StepMoveNew, func() error { return os.Rename(newPath, currentPath) }},
+		{apply.StepStart, func() error { return svc.Start(ctx) }},
+	}
+	for _, s := range steps {
+		if s.name == a.crashAt {
+			return "", errCrash
+		}
+		apply.ReportStep(ctx, s.name)
+		if err := s.do(); err != nil {
+			return "", err
+		}
+	}
+	return oldPath, nil
+}
+
+// install sets up dir with the given files (name -> content).
+func install(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRollbackUnhealthyClearsBackupVersion(t *testing.T) {
+	dir := install(t, map[string]string{"agent": "v2", "agent.old": "v1"})
+	u := New(Config{InstallDir: dir, ExeName: "agent", Applier: renameApplier{}})
+	if err := u.recordInstall("1.0.0", "0.9.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	cause := errors.New("unhealthy")
+	err := u.rollbackUnhealthy(context.Background(), "2.0.0", filepath.Join(dir, "agent.old"), cause)
+	var rerr *RollbackError
+	if !errors.As(err, &rerr) || rerr.RestoreErr != nil || !errors.Is(err, ErrRolledBack) || !errors.Is(err, cause) {
+		t.Fatalf("got %v, want a RollbackError with the restore done", err)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "agent")); got != "v1" {
+		t.Fatalf("current binary = %q, want the backup", got)
+	}
+	for _, name := range []string{"agent.old", "agent.new", "agent.journal.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind", name)
+		}
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BackupVersion != "" {
+		t.Errorf("BackupVersion = %q after the backup was restored", st.BackupVersion)
+	}
+	if !u.rolledBack("2.0.0") {
+		t.Error("2.0.0 not recorded as rolled back")
+	}
+
+	// nothing left to roll back to
+	if _, err := u.Rollback(context.Background()); !errors.Is(err, ErrNoBackup) {
+		t.Fatalf("Rollback = %v, want ErrNoBackup", err)
+	}
+}
@@ -0,0 +1,105 @@
+package updater
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Rollout limits a release to a share of devices. Nobody gets it before
+// StartAt; from then on Percentage applies, raised by each Ramp step once
+// its After (a Go duration such as "24h", counted from StartAt, or from
+// published_at when StartAt is empty) has passed.
+type Rollout struct {
+	Percentage float64    `json:"percentage"`
+	StartAt    time.Time  `json:"start_at,omitempty"`
+	Ramp       []RampStep `json:"ramp,omitempty"`
+}
+
+type RampStep struct {
+	After      string  `json:"after"`
+	Percentage float64 `json:"percentage"`
+}
+
+// RolloutDecision reports whether this device is inside the current
+// rollout. Bucket is the device's stable position in [0, 100) for this
+// product and version; the device is eligible while Bucket < Percentage.
+type RolloutDecision struct {
+	Percentage float64
+	Bucket     float64
+	Eligible   bool
+	DeviceID   string
+}
+
+// percentageAt returns the rollout share at time now.
+func (r *Rollout) percentageAt(publishedAt, now time.Time) (float64, error) {
+	start := r.StartAt
+	if start.IsZero() {
+		start = publishedAt
+	}
+	if !r.StartAt.IsZero() && now.Before(r.StartAt) {
+		return 0, nil
+	}
+	pct := r.Percentage
+	var last time.Duration
+	for _, step := range r.Ramp {
+		d, err := time.ParseDuration(step.After)
+		if err != nil {
+			return 0, fmt.Errorf("rollout ramp: %w", err)
+		}
+		if d < last {
+			return 0, fmt.Errorf("rollout ramp: steps out of order at %q", step.After)
+		}
+		last = d
+		if !start.IsZero() && !now.Before(start.Add(d)) {
+			pct = step.Percentage
+		}
+	}
+	return pct, nil
+}
+
+func (u *Updater) rolloutDecision(m *Manifest, now time.Time) (*RolloutDecision, error) {
+	pct, err := m.Rollout.percentageAt(m.PublishedAt, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := u.deviceID()
+	if err != nil {
+		return nil, err
+	}
+	b := rolloutBucket(id, m.Product, m.Version)
+	return &RolloutDecision{Percentage: pct, Bucket: b, Eligible: b < pct, DeviceID: id}, nil
+}
+
+// rolloutBucket maps a device to [0, 100), stable per product and version.
+func rolloutBucket(deviceID, product, version string) float64 {
+	sum := sha256.Sum256([]byte(deviceID + "\x00" + product + "\x00" + version))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53) * 100
+}
+
+// deviceID returns Config.DeviceID, or a random id generated once and kept
+// in the state file.
+func (u *Updater) deviceID() (string, error) {
+	if u.cfg.DeviceID != "" {
+		return u.cfg.DeviceID, nil
+	}
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return "", err
+	}
+	if st.DeviceID != "" {
+		return st.DeviceID, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	st.DeviceID = hex.EncodeToString(b)
+	if err := st.save(u.cfg.StateFile); err != nil {
+		return "", fmt.Errorf("save updater state: %w", err)
+	}
+	return st.DeviceID, nil
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	tests := []struct {
+		device, product, version string
+		want                     float64
+	}{
+		{"device-4", "agent", "2.0.0", 10.7129435479},
+		{"device-4", "agent", "2.1.0", 77.6104316653},
+		{"device-1", "agent", "2.0.0", 65.5421505273},
+	}
+	for _, tt := range tests {
+		if got := rolloutBucket(tt.device, tt.product, tt.version); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("rolloutBucket(%s, %s, %s) = %.10f, want %.10f", tt.device, tt.product, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestRolloutDecision(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	published := start.Add(-24 * time.Hour)
+	ramp := []RampStep{{After: "1h", Percentage: 25}, {After: "24h", Percentage: 50}, {After: "48h", Percentage: 100}}
+
+	// device-4 sits at 10.71 for agent 2.0.0
+	tests := []struct {
+		name    string
+		rollout Rollout
+		now     time.Time
+		wantPct float64
+	}{
+		{"before start_at", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(-time.Nanosecond), 0},
+		{"at start_at", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start, 5},
+		{"just before the first step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(time.Hour - time.Nanosecond), 5},
+		{"first step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(time.Hour), 25},
+		{"just before the second step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(24*time.Hour - time.Nanosecond), 25},
+		{"second step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(24 * time.Hour), 50},
+		{"just before the last step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(48*time.Hour - time.Nanosecond), 50},
+		{"last step", Rollout{Percentage: 5, StartAt: start, Ramp: ramp}, start.Add(48 * time.Hour), 100},
+		{"ramp from published_at", Rollout{Percentage: 5, Ramp: ramp}, published.Add(time.Hour), 25},
+		{"published_at does not gate", Rollout{Percentage: 5, Ramp: ramp}, published.Add(-time.Hour), 5},
+		{"fixed percentage", Rollout{Percentage: 20}, start, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", DeviceID: "device-4"})
+			m := &Manifest{Product: "agent", Version: "2.0.0", PublishedAt: published, Rollout: &tt.rollout}
+			d, err := u.rolloutDecision(m, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Percentage != tt.wantPct || d.Eligible != (tt.wantPct > 10.7129435479) || d.DeviceID != "device-4" {
+				t.Fatalf("decision = %+v, want %v%% (bucket 10.71)", d, tt.wantPct)
+			}
+		})
+	}
+
+	for _, steps := range [][]RampStep{
+		{{After: "one day", Percentage: 50}},
+		{{After: "24h", Percentage: 50}, {After: "1h", Percentage: 100}},
+	} {
+		u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", DeviceID: "device-4"})
+		m := &Manifest{Product: "agent", Version: "2.0.0", Rollout: &Rollout{StartAt: start, Ramp: steps}}
+		if _, err := u.rolloutDecision(m, start.Add(48*time.Hour)); err == nil {
+			t.Errorf("ramp %+v accepted", steps)
+		}
+	}
+}
+
+func TestRolloutDeviceIDPersisted(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{InstallDir: dir, ExeName: "agent", StateFile: filepath.Join(dir, "state.json")}
+	first, err := New(cfg).deviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(cfg).deviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == "" || first != second {
+		t.Fatalf("device ids %q and %q, want one stable id", first, second)
+	}
+}
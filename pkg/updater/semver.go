@@ -0,0 +1,173 @@
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SemVer is a parsed Semantic Versioning 2.0.0 version.
+type SemVer struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      string
+}
+
+// ParseSemVer strictly parses a SemVer 2.0.0 version. A leading "v" is
+// allowed; anything else outside the spec is an error.
+func ParseSemVer(s string) (SemVer, error) {
+	var v SemVer
+	in := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.Build = s[i+1:]
+		s = s[:i]
+		if err := checkIdents(v.Build, false); err != nil {
+			return SemVer{}, fmt.Errorf("semver %q: build: %w", in, err)
+		}
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre := s[i+1:]
+		s = s[:i]
+		if err := checkIdents(pre, true); err != nil {
+			return SemVer{}, fmt.Errorf("semver %q: prerelease: %w", in, err)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+
+	core := strings.Split(s, ".")
+	if len(core) != 3 {
+		return SemVer{}, fmt.Errorf("semver %q: want MAJOR.MINOR.PATCH", in)
+	}
+	nums := make([]uint64, 3)
+	for i, p := range core {
+		n, err := parseNumIdent(p)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("semver %q: %w", in, err)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare follows SemVer precedence: build metadata is ignored and a
+// prerelease sorts before its release.
+func (v SemVer) Compare(o SemVer) int {
+	if c := cmpUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func comparePrerelease(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aErr := strconv.ParseUint(a[i], 10, 64)
+		bn, bErr := strconv.ParseUint(b[i], 10, 64)
+		aNum, bNum := aErr == nil, bErr == nil
+		switch {
+		case aNum && bNum:
+			if c := cmpUint(an, bn); c != 0 {
+				return c
+			}
+		case aNum:
+			// numeric identifiers have lower precedence
+			return -1
+		case bNum:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(a), len(b))
+}
+
+func checkIdents(s string, prerelease bool) error {
+	if s == "" {
+		return fmt.Errorf("empty")
+	}
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return fmt.Errorf("empty identifier")
+		}
+		numeric := true
+		for _, c := range id {
+			switch {
+			case c >= '0' && c <= '9':
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-':
+				numeric = false
+			default:
+				return fmt.Errorf("invalid character %q in %q", c, id)
+			}
+		}
+		if prerelease && numeric && len(id) > 1 && id[0] == '0' {
+			return fmt.Errorf("leading zero in %q", id)
+		}
+	}
+	return nil
+}
+
+func parseNumIdent(p string) (uint64, error) {
+	if p == "" {
+		return 0, fmt.Errorf("empty version number")
+	}
+	if len(p) > 1 && p[0] == '0' {
+		return 0, fmt.Errorf("leading zero in %q", p)
+	}
+	for _, c := range p {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("non-numeric %q", p)
+		}
+	}
+	return strconv.ParseUint(p, 10, 64)
+}
+
+func cmpUint(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package updater
+
+import "testing"
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // String() of the result; "" = error
+		wantPre int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{" 1.2.3 ", "1.2.3", 0},
+		{"0.0.0", "0.0.0", 0},
+		{"1.0.0-alpha", "1.0.0-alpha", 1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.1", 2},
+		{"1.0.0-0.3.7", "1.0.0-0.3.7", 3},
+		{"1.0.0-x.7.z.92", "1.0.0-x.7.z.92", 4},
+		{"1.0.0-x-y-z.--", "1.0.0-x-y-z.--", 2},
+		{"1.0.0+20130313144700", "1.0.0+20130313144700", 0},
+		{"1.0.0-beta+exp.sha.5114f85", "1.0.0-beta+exp.sha.5114f85", 1},
+		{"1.0.0+001", "1.0.0+001", 0}, // leading zeros are fine in build metadata
+		{"18446744073709551615.0.0", "18446744073709551615.0.0", 0},
+
+		{"1.2", "", 0},
+		{"1.2.3.4", "", 0},
+		{"01.2.3", "", 0},
+		{"1.02.3", "", 0},
+		{"1.2.3-01", "", 0},
+		{"1.2.3-", "", 0},
+		{"1.2.3-a..b", "", 0},
+		{"1.2.3+", "", 0},
+		{"1.2.3-a_b", "", 0},
+		{"1.x.3", "", 0},
+		{"18446744073709551616.0.0", "", 0},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		v, err := ParseSemVer(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseSemVer(%q) = %v, want error", tt.in, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSemVer(%q): %v", tt.in, err)
+			continue
+		}
+		if v.String() != tt.want || len(v.Prerelease) != tt.wantPre {
+			t.Errorf("ParseSemVer(%q) = %q (%d prerelease ids), want %q (%d)", tt.in, v, len(v.Prerelease), tt.want, tt.wantPre)
+		}
+	}
+}
+
+// semver.org §11: each version has lower precedence than the next.
+var precedence = []string{
+	"1.0.0-alpha",
+	"1.0.0-alpha.1",
+	"1.0.0-alpha.beta",
+	"1.0.0-beta",
+	"1.0.0-beta.2",
+	"1.0.0-beta.11",
+	"1.0.0-rc.1",
+	"1.0.0",
+	"1.0.1",
+	"1.1.0",
+	"2.0.0",
+	"10.0.0",
+}
+
+func TestSemVerPrecedence(t *testing.T) {
+	for _, cmp := range []struct {
+		name string
+		c    VersionComparer
+	}{{"strict", SemVerComparer{}}, {"loose", LooseVersionComparer{}}} {
+		for i, a := range precedence {
+			for j, b := range precedence {
+				got, err := cmp.c.Compare(a, b)
+				if err != nil {
+					t.Fatalf("%s: Compare(%q, %q): %v", cmp.name, a, b, err)
+				}
+				if want := cmpInt(i, j); got != want {
+					t.Errorf("%s: Compare(%q, %q) = %d, want %d", cmp.name, a, b, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0+build.1", "1.0.0+build.2", 0}, // build metadata is ignored
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2", "1.2.1", -1},
+		{"1.2.3.4", "1.2.3", 1},
+		{"1.02.3", "1.2.3", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-rc.1", "1.0", -1},
+		{"2.0.0-beta.1", "1.9.9", 1},
+		{"1.0.0-", "1.0.0", 1}, // not loosely numeric: legacy ordering, ties broken bytewise
+		{"abc", "abc", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersion(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersion(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersion(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestSemVerComparerRejectsInvalid(t *testing.T) {
+	for _, pair := range [][2]string{{"1.2", "1.2.0"}, {"1.2.0", "latest"}, {"", "1.0.0"}} {
+		if _, err := (SemVerComparer{}).Compare(pair[0], pair[1]); err == nil {
+			t.Errorf("Compare(%q, %q): want error", pair[0], pair[1])
+		}
+	}
+}
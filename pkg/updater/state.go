@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrManifestExpired  = errors.New("manifest has expired")
+	ErrManifestReplayed = errors.New("manifest is older than one already accepted")
+	ErrVersionRollback  = errors.New("manifest version is lower than one already installed")
+)
+
+// state is what the updater remembers between runs, kept in Config.StateFile.
+type state struct {
+	HighestVersion    string    `json:"highest_version,omitempty"`     // highest version installed
+	NewestPublishedAt time.Time `json:"newest_published_at,omitempty"` // newest manifest seen by Check
+	DeviceID          string    `json:"device_id,omitempty"`
+	RolledBack        []string  `json:"rolled_back,omitempty"` // versions rolled back (health checks or Rollback)
+
+	// what the last update installed and what its backup holds
+	InstalledVersion string    `json:"installed_version,omitempty"`
+	InstalledAt      time.Time `json:"installed_at,omitempty"`
+	BackupVersion    string    `json:"backup_version,omitempty"`
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("decode state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+func (st *state) save(path string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkFreshness rejects expired manifests, manifests published before the
+// newest one seen and versions below the highest one installed. It records
+// m's published_at, so Check writes the state file; the version floor is
+// only raised by recordInstall, so a release that was offered but never
+// installed can still be pulled.
+func (u *Updater) checkFreshness(m *Manifest) error {
+	if !m.ExpiresAt.IsZero() && !time.Now().Before(m.ExpiresAt) {
+		return fmt.Errorf("%w: expires_at=%s", ErrManifestExpired, m.ExpiresAt.Format(time.RFC3339))
+	}
+
+	st, err := loadState(u.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	// a manifest without published_at cannot be ordered by date
+	if !m.PublishedAt.IsZero() && m.PublishedAt.Before(st.NewestPublishedAt) {
+		return fmt.Errorf("%w: published_at=%s, seen %s", ErrManifestReplayed,
+			m.PublishedAt.Format(time.RFC3339), st.NewestPublishedAt.Format(time.RFC3339))
+	}
+	version := m.Version
+	if len(m.Releases) > 0 {
+		if version, err = u.newestRelease(m); err != nil {
+			return err
+		}
+	}
+	// an index with no release for this client is left to selectRelease
+	if version != "" && st.HighestVersion != "" {
+		c, err := u.cfg.VersionComparer.Compare(version, st.HighestVersion)
+		if err != nil {
+			return fmt.Errorf("compare versions: %w", err)
+		}
+		if c < 0 {
+			return fmt.Errorf("%w: version=%s, installed %s", ErrVersionRollback, version, st.HighestVersion)
+		}
+	}
+
+	if m.PublishedAt.After(st.NewestPublishedAt) {
+		st.NewestPublishedAt = m.PublishedAt
+		if err := st.save(u.cfg.StateFile); err != nil {
+			return fmt.Errorf("save updater state: %w", err)
+		}
+	}
+	return nil
+}
+
+// raiseFloor makes version the anti-rollback floor if it is higher.
+func (u *Updater) raiseFloor(st *state, version string) {
+	if version == "" {
+		return
+	}
+	if st.HighestVersion != "" {
+		if c, err := u.cfg.VersionComparer.Compare(version, st.HighestVersion); err != nil || c <= 0 {
+			return
+		}
+	}
+	st.HighestVersion = version
+}
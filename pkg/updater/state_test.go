@@ -0,0 +1,143 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	t0 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	index := func(rs ...Manifest) *Manifest { return &Manifest{PublishedAt: t0, Releases: rs} }
+	rel := func(v, ch string) Manifest { return Manifest{Version: v, Channel: ch} }
+
+	tests := []struct {
+		name      string
+		channel   string
+		installed string      // recorded by a previous update
+		seen      []*Manifest // checked first
+		m         *Manifest
+		wantErr   error
+	}{
+		{
+			name: "first manifest",
+			m:    &Manifest{Version: "1.2.0", PublishedAt: t0},
+		},
+		{
+			name:      "older than installed is a rollback",
+			installed: "1.2.0",
+			m:         &Manifest{Version: "1.1.0", PublishedAt: t0.Add(time.Hour)},
+			wantErr:   ErrVersionRollback,
+		},
+		{
+			name:      "installed version again",
+			installed: "1.2.0",
+			m:         &Manifest{Version: "1.2.0", PublishedAt: t0},
+		},
+		{
+			name: "pulled release that was never installed",
+			seen: []*Manifest{{Version: "2.0.0", PublishedAt: t0}},
+			m:    &Manifest{Version: "1.9.1", PublishedAt: t0.Add(time.Hour)},
+		},
+		{
+			name:    "older published_at is a replay",
+			seen:    []*Manifest{{Version: "1.2.0", PublishedAt: t0}},
+			m:       &Manifest{Version: "1.3.0", PublishedAt: t0.Add(-time.Hour)},
+			wantErr: ErrManifestReplayed,
+		},
+		{
+			name: "no published_at is not a replay",
+			seen: []*Manifest{{Version: "1.2.0", PublishedAt: t0}},
+			m:    &Manifest{Version: "1.3.0"},
+		},
+		{
+			name:    "expired",
+			m:       &Manifest{Version: "1.2.0", PublishedAt: t0, ExpiresAt: time.Now().Add(-time.Minute)},
+			wantErr: ErrManifestExpired,
+		},
+		{
+			name:      "index counts its newest stable release",
+			installed: "1.1.0",
+			m:         index(rel("1.0.0", "stable"), rel("1.1.0", "stable"), rel("2.0.0-nightly.1", "nightly")),
+		},
+		{
+			name:      "nightly client counts nightly releases",
+			channel:   "nightly",
+			installed: "1.5.0",
+			m:         index(rel("1.1.0", "stable"), rel("2.0.0-nightly.1", "nightly")),
+		},
+		{
+			name:      "index without releases for the client",
+			installed: "1.2.0",
+			m:         index(rel("0.9.0", "nightly")),
+		},
+		{
+			name:      "stable rollback in an index is caught despite a newer nightly",
+			installed: "1.2.0",
+			m:         index(rel("1.1.0", "stable"), rel("3.0.0", "nightly")),
+			wantErr:   ErrVersionRollback,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := New(Config{InstallDir: t.TempDir(), ExeName: "agent", Channel: tt.channel})
+			if tt.installed != "" {
+				if err := u.recordInstall(tt.installed, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, m := range tt.seen {
+				if err := u.checkFreshness(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := u.checkFreshness(tt.m)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			st, err := loadState(u.cfg.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// checking never raises the floor
+			if st.HighestVersion != tt.installed {
+				t.Fatalf("floor = %q, want %q", st.HighestVersion, tt.installed)
+			}
+		})
+	}
+}
+
+func TestFloorFollowsInstalls(t *testing.T) {
+	u := New(Config{InstallDir: t.TempDir(), ExeName: "agent"})
+	floor := func() string {
+		t.Helper()
+		st, err := loadState(u.cfg.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.HighestVersion
+	}
+
+	for _, v := range []string{"1.0.0", "2.0.0", "1.5.0"} {
+		if err := u.recordInstall(v, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := floor(); got != "2.0.0" {
+		t.Fatalf("floor = %q after installs, want the highest", got)
+	}
+	if err := u.checkFreshness(&Manifest{Version: "1.9.0"}); !errors.Is(err, ErrVersionRollback) {
+		t.Fatalf("got %v, want ErrVersionRollback", err)
+	}
+
+	// 2.0.0 was rolled back and pulled; the release replacing it is accepted
+	if err := u.recordRollback("2.0.0", "1.0.0", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := floor(); got != "1.0.0" {
+		t.Fatalf("floor = %q after rollback, want 1.0.0", got)
+	}
+	if err := u.checkFreshness(&Manifest{Version: "1.9.0"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,8 +7,24 @@ type Manifest struct {
 	Channel     string     `json:"channel"`
 	Version     string     `json:"version"`
 	PublishedAt time.Time  `json:"published_at"`
+	ExpiresAt   time.Time  `json:"expires_at,omitempty"`
 	Notes       string     `json:"notes"`
 	Artifacts   []Artifact `json:"artifacts"`
+
+	// optional binary patches from older builds to this version
+	Patches []Patch `json:"patches,omitempty"`
+
+	// optional staged rollout; see Rollout
+	Rollout *Rollout `json:"rollout,omitempty"`
+
+	// optional id of the key that signed this manifest (see verify.KeyRing)
+	KeyID string `json:"key_id,omitempty"`
+
+	// index form: the release history, one entry per version with its own
+	// channel, published_at, notes, artifacts and patches. Check picks the
+	// target (see Config.PinVersion and Config.VersionConstraint); product,
+	// expires_at and key_id come from the index itself.
+	Releases []Manifest `json:"releases,omitempty"`
 }
 
 type Artifact struct {
@@ -17,6 +33,48 @@ type Artifact struct {
 	Name   string `json:"name"`
 	URL    string `json:"url"`
 	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size,omitempty"` // bytes; optional
+
+	// optional detached Ed25519 signature over the downloaded file,
+	// inline (base64/hex) or fetched from SignatureURL
+	Signature    string `json:"signature,omitempty"`
+	SignatureURL string `json:"signature_url,omitempty"`
+
+	// optional alternative locations of the same file; SHA256 still decides
+	Mirrors []Mirror `json:"mirrors,omitempty"`
+
+	// archive artifacts: Format is "tar.gz" or "zip" (empty = raw binary);
+	// Path is the executable inside it (default: Config.ExeName).
+	// SHA256 and Signature cover the archive itself.
+	Format string `json:"format,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// optional minimum OS version (e.g. macOS "10.13"); see Config.OSVersion
+	MinOSVersion string `json:"min_os_version,omitempty"`
+}
+
+// Patch turns the installed binary (identified by FromSHA256, and
+// FromVersion when set) into this release. SHA256 is the patch file's hash;
+// ToSHA256 is the resulting binary's hash and defaults to the artifact's
+// SHA256 for raw artifacts. Format is "bsdiff" (default).
+type Patch struct {
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	FromVersion string `json:"from_version,omitempty"`
+	FromSHA256  string `json:"from_sha256"`
+	URL         string `json:"url"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size,omitempty"`
+	ToSHA256    string `json:"to_sha256,omitempty"`
+	Format      string `json:"format,omitempty"`
+}
+
+// Mirror is an alternative download URL. Lower Priority is tried first;
+// Weight (default 1) spreads load between mirrors of equal priority.
+type Mirror struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
 }
 
 type CheckResult struct {
@@ -25,6 +83,12 @@ type CheckResult struct {
 	UpdateAvailable bool
 	Artifact        *Artifact
 	Notes           string
+
+	// set when the release has a rollout; UpdateAvailable is false for
+	// devices outside it
+	Rollout *RolloutDecision
+
+	manifest *Manifest
 }
 
 type UpdateResult struct {
@@ -32,4 +96,8 @@ type UpdateResult struct {
 	OldBackupPath string
 	NewBinaryPath string
 	RemoteVersion string
+	MirrorURL     string // URL the artifact (or patch) was downloaded from
+	Patched       bool   // built from a binary patch instead of the full artifact
+
+	Rollout *RolloutDecision // see CheckResult.Rollout
 }
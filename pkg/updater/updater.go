@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/blitzh/go-autoupdater/pkg/apply"
+	"github.com/blitzh/go-autoupdater/pkg/health"
 	"github.com/blitzh/go-autoupdater/pkg/service"
 	"github.com/blitzh/go-autoupdater/pkg/util"
 	"github.com/blitzh/go-autoupdater/pkg/verify"
@@ -24,16 +26,89 @@ type Config struct {
 
 	InstallDir string
 	ExeName    string // agent.exe / agent
-	Channel    string
-	Product    string
+
+	// a manifest for another Product, or from a channel less stable than
+	// Channel (empty = the first of ChannelOrder, default
+	// DefaultChannelOrder), is rejected with a *MismatchError unless
+	// explicitly allowed
+	Product              string
+	Channel              string
+	ChannelOrder         []string
+	AllowProductMismatch bool
+	AllowChannelMismatch bool
 
 	Source  Source
 	Service service.Controller
 	Applier apply.Applier
 
+	// artifact signatures: when ArtifactVerifier is set, signed artifacts are
+	// checked before apply; RequireArtifactSignature also rejects unsigned ones
+	ArtifactVerifier         verify.SignatureVerifier
+	RequireArtifactSignature bool
+
+	// version ordering; default LooseVersionComparer (SemVer-aware, lenient)
+	VersionComparer VersionComparer
+
+	// anti-rollback state (highest version installed, newest published_at
+	// seen), written by Check as well as Update;
+	// default: <InstallDir>/<exe>.state.json
+	StateFile string
+
+	// write-ahead journal of binary swaps, read by Recover; default:
+	// <InstallDir>/<exe>.journal.json
+	JournalFile string
+
 	// download behavior
 	UserAgent string
 	MinBytes  int64
+	Retry     *util.RetryPolicy // default util.DefaultRetryPolicy()
+
+	// HTTPClient is used for artifacts, patches and signatures, e.g. one
+	// that authenticates to a registry; it should not set a Timeout since
+	// the context bounds the update.
+	HTTPClient *http.Client
+
+	// OnProgress receives download progress (bytes, total, throughput, ETA)
+	OnProgress func(util.Progress)
+
+	// order in which Artifact.URL and Artifact.Mirrors are tried
+	MirrorStrategy MirrorStrategy
+
+	// release selection when the manifest is an index (has Releases):
+	// PinVersion installs exactly that version (never a downgrade), else
+	// the highest release in Channel matching VersionConstraint, e.g.
+	// "~1.4" (see ParseConstraint); empty means the latest
+	PinVersion        string
+	VersionConstraint string
+
+	// staged rollouts: DeviceID (default: random, kept in StateFile) picks
+	// this device's bucket; IgnoreRollout installs regardless
+	DeviceID      string
+	IgnoreRollout bool
+
+	// OS version compared with Artifact.MinOSVersion; detected on macOS,
+	// unknown (not checked) elsewhere unless set
+	OSVersion string
+
+	// post-update health checks: each is retried every HealthInterval
+	// (default 1s) until it passes; if any still fails after HealthTimeout
+	// (default 60s) the previous binary is restored and Update returns a
+	// *RollbackError
+	HealthChecks   []health.Check
+	HealthTimeout  time.Duration
+	HealthInterval time.Duration
+
+	// versioned backups: besides <exe>.old, each replaced binary is kept in
+	// BackupDir (default <InstallDir>/<exe>.backups) with its version,
+	// sha256 and install time; the newest BackupKeep (default 3, < 0
+	// disables) are retained, fewer when BackupMaxBytes is exceeded
+	BackupDir      string
+	BackupKeep     int
+	BackupMaxBytes int64
+
+	// size limit for the executable extracted from an archive artifact;
+	// default 1 GiB
+	MaxExtractBytes int64
 
 	// logging
 	Logger  *util.Logger
@@ -51,9 +126,27 @@ func New(cfg Config) *Updater {
 	if cfg.MinBytes == 0 {
 		cfg.MinBytes = 32 * 1024
 	}
+	if cfg.MaxExtractBytes == 0 {
+		cfg.MaxExtractBytes = 1 << 30
+	}
+	if cfg.Retry == nil {
+		cfg.Retry = util.DefaultRetryPolicy()
+	}
 	if cfg.Service == nil {
 		cfg.Service = service.NoopController{}
 	}
+	if cfg.OSVersion == "" {
+		cfg.OSVersion = hostOSVersion()
+	}
+	if cfg.VersionComparer == nil {
+		cfg.VersionComparer = LooseVersionComparer{}
+	}
+	if cfg.StateFile == "" {
+		cfg.StateFile = filepath.Join(cfg.InstallDir, strings.TrimSuffix(cfg.ExeName, ".exe")+".state.json")
+	}
+	if cfg.JournalFile == "" {
+		cfg.JournalFile = filepath.Join(cfg.InstallDir, strings.TrimSuffix(cfg.ExeName, ".exe")+".journal.json")
+	}
 	if cfg.Logger == nil && cfg.LogFile != "" {
 		cfg.Logger = util.NewLogger(cfg.LogFile)
 	}
@@ -66,6 +159,17 @@ func (u *Updater) logf(format string, a ...any) {
 	}
 }
 
+// retryPolicy is Config.Retry with retries logged.
+func (u *Updater) retryPolicy() *util.RetryPolicy {
+	p := *u.cfg.Retry
+	if p.OnRetry == nil {
+		p.OnRetry = func(attempt int, err error, delay time.Duration) {
+			u.logf("attempt %d failed: %v; retrying in %s", attempt, err, delay.Round(time.Millisecond))
+		}
+	}
+	return &p
+}
+
 func (u *Updater) currentPath() string {
 	return filepath.Join(u.cfg.InstallDir, u.cfg.ExeName)
 }
@@ -79,6 +183,9 @@ func (u *Updater) stagingPaths() (newPath, oldPath string) {
 	return cur + ".new", cur + ".old"
 }
 
+// Check fetches the manifest and reports whether it offers an update. It
+// records the manifest's published_at in the state file for replay
+// protection; nothing else is changed.
 func (u *Updater) Check(ctx context.Context) (*CheckResult, error) {
 	if u.cfg.Source == nil {
 		return nil, errors.New("Source is nil")
@@ -88,45 +195,86 @@ func (u *Updater) Check(ctx context.Context) (*CheckResult, error) {
 		return nil, err
 	}
 
-	if u.cfg.Product != "" && m.Product != "" && u.cfg.Product != m.Product {
-		// not fatal; just warn in notes
+	// the channels of an index's releases are filtered by selectRelease
+	target := m
+	if len(m.Releases) > 0 {
+		target = &Manifest{Product: m.Product}
 	}
-	if u.cfg.Channel != "" && m.Channel != "" && u.cfg.Channel != m.Channel {
-		// still ok; but likely user should point to matching manifest
+	if err := u.checkTarget(target); err != nil {
+		return nil, err
 	}
 
-	a := selectArtifact(m, runtime.GOOS, runtime.GOARCH)
+	if err := u.checkFreshness(m); err != nil {
+		return nil, err
+	}
+	if len(m.Releases) > 0 {
+		if m, err = u.selectRelease(m); err != nil {
+			return nil, err
+		}
+	}
+
+	a, selErr := selectArtifact(m, runtime.GOOS, runtime.GOARCH, u.cfg.OSVersion)
 	res := &CheckResult{
 		CurrentVersion:  u.cfg.CurrentVersion,
 		RemoteVersion:   m.Version,
 		Notes:           m.Notes,
 		Artifact:        a,
 		UpdateAvailable: false,
+		manifest:        m,
 	}
 	if a == nil {
-		return res, fmt.Errorf("no artifact for os=%s arch=%s", runtime.GOOS, runtime.GOARCH)
+		return res, selErr
+	}
+
+	inRollout := true
+	if m.Rollout != nil && !u.cfg.IgnoreRollout {
+		d, err := u.rolloutDecision(m, time.Now())
+		if err != nil {
+			return res, err
+		}
+		res.Rollout = d
+		inRollout = d.Eligible
+		u.logf("rollout %s: %.1f%%, device bucket %.2f, eligible=%v", m.Version, d.Percentage, d.Bucket, d.Eligible)
 	}
 
 	// If no current version provided, always say update available (caller can decide)
 	if strings.TrimSpace(u.cfg.CurrentVersion) == "" {
-		res.UpdateAvailable = true
-		return res, nil
+		res.UpdateAvailable = inRollout
+	} else {
+		c, err := u.cfg.VersionComparer.Compare(u.cfg.CurrentVersion, m.Version)
+		if err != nil {
+			return res, fmt.Errorf("compare versions: %w", err)
+		}
+		if c < 0 {
+			res.UpdateAvailable = inRollout
+		}
 	}
-
-	if CompareVersion(u.cfg.CurrentVersion, m.Version) < 0 {
-		res.UpdateAvailable = true
+	if res.UpdateAvailable && u.cfg.PinVersion == "" && u.rolledBack(m.Version) {
+		u.logf("%s was rolled back before; skipping", m.Version)
+		res.UpdateAvailable = false
 	}
 	return res, nil
 }
 
-func selectArtifact(m *Manifest, osName, arch string) *Artifact {
+// selectArtifact skips artifacts whose MinOSVersion is above osVersion
+// (unknown osVersion: not checked).
+func selectArtifact(m *Manifest, osName, arch, osVersion string) (*Artifact, error) {
+	tooOld := ""
 	for i := range m.Artifacts {
 		a := m.Artifacts[i]
-		if strings.EqualFold(a.OS, osName) && strings.EqualFold(a.Arch, arch) {
-			return &a
+		if !strings.EqualFold(a.OS, osName) || !strings.EqualFold(a.Arch, arch) {
+			continue
+		}
+		if a.MinOSVersion != "" && osVersion != "" && CompareVersion(osVersion, a.MinOSVersion) < 0 {
+			tooOld = a.MinOSVersion
+			continue
 		}
+		return &a, nil
 	}
-	return nil
+	if tooOld != "" {
+		return nil, fmt.Errorf("artifact for os=%s arch=%s needs os version %s or later (running %s)", osName, arch, tooOld, osVersion)
+	}
+	return nil, fmt.Errorf("no artifact for os=%s arch=%s", osName, arch)
 }
 
 func (u *Updater) Update(ctx context.Context) (*UpdateResult, error) {
@@ -135,50 +283,179 @@ func (u *Updater) Update(ctx context.Context) (*UpdateResult, error) {
 		return nil, err
 	}
 	if !chk.UpdateAvailable {
-		return &UpdateResult{DidUpdate: false, RemoteVersion: chk.RemoteVersion}, nil
+		return &UpdateResult{DidUpdate: false, RemoteVersion: chk.RemoteVersion, Rollout: chk.Rollout}, nil
 	}
 	if chk.Artifact == nil {
 		return nil, fmt.Errorf("artifact is nil")
 	}
 
+	signed := chk.Artifact.Signature != "" || chk.Artifact.SignatureURL != ""
+	if strings.TrimSpace(chk.Artifact.SHA256) == "" && (u.cfg.ArtifactVerifier == nil || !signed) {
+		return nil, errors.New("artifact has no sha256 and no signature to verify")
+	}
+	if u.cfg.RequireArtifactSignature {
+		if u.cfg.ArtifactVerifier == nil {
+			return nil, errors.New("artifact signature required but ArtifactVerifier is nil")
+		}
+		if !signed {
+			return nil, errors.New("artifact signature required but manifest has none")
+		}
+	}
+
+	format, err := util.NormalizeArchiveFormat(chk.Artifact.Format)
+	if err != nil {
+		return nil, err
+	}
+
 	newPath, oldPath := u.stagingPaths()
 	curPath := u.currentPath()
 
 	u.logf("update available: %s -> %s", chk.CurrentVersion, chk.RemoteVersion)
-	u.logf("downloading: %s", chk.Artifact.URL)
 
-	// Download to staging newPath
-	if err := util.DownloadToFile(ctx, chk.Artifact.URL, newPath, u.cfg.UserAgent, u.cfg.MinBytes); err != nil {
-		return nil, err
+	// try a binary patch first; any failure falls back to the full artifact.
+	// An archive's signature covers the archive, which a patch never
+	// downloads, so a signed archive is fetched in full to check it.
+	var mirror string
+	patched := false
+	checkSig := u.cfg.RequireArtifactSignature || (u.cfg.ArtifactVerifier != nil && signed)
+	if p, target := u.selectPatch(chk.manifest, chk.Artifact, runtime.GOOS, runtime.GOARCH); p != nil && format != "" && checkSig {
+		u.logf("skipping patch: the archive signature can only be checked on a full download")
+	} else if p != nil {
+		u.logf("trying patch from %s", p.FromSHA256)
+		if err := u.applyPatch(ctx, p, target, newPath); err != nil {
+			u.logf("patch failed, using full artifact: %v", err)
+		} else {
+			u.logf("patch applied, sha256 verified")
+			mirror, patched = p.URL, true
+		}
 	}
-	u.logf("downloaded to: %s", newPath)
 
-	// Verify SHA256 (required)
-	if err := verify.VerifyFileSHA256(newPath, chk.Artifact.SHA256); err != nil {
-		return nil, err
+	if !patched {
+		if mirror, err = u.fetchFull(ctx, chk.Artifact, format, newPath); err != nil {
+			return nil, err
+		}
+	} else if format == "" {
+		// the patched file is the artifact itself
+		if err := u.verifyArtifactSignature(ctx, chk.Artifact, newPath); err != nil {
+			return nil, err
+		}
 	}
-	u.logf("sha256 verified")
 
 	// Apply swap
 	if u.cfg.Applier == nil {
 		return nil, fmt.Errorf("Applier is nil")
 	}
-
-	oldBackup, err := u.cfg.Applier.Apply(ctx, u.cfg.Service, curPath, newPath, oldPath)
+	j, err := u.beginSwap(opUpdate, u.cfg.CurrentVersion, chk.RemoteVersion, newPath, oldPath)
+	if err != nil {
+		return nil, err
+	}
+	oldBackup, err := u.swap(ctx, j)
 	if err != nil {
 		return nil, err
 	}
 
 	u.logf("apply ok, old backup: %s", oldBackup)
 
+	if len(u.cfg.HealthChecks) > 0 {
+		if err := u.checkHealth(ctx); err != nil {
+			return nil, u.rollbackUnhealthy(ctx, chk.RemoteVersion, oldBackup, err)
+		}
+		u.logf("health checks passed")
+	}
+	if err := u.keepBackup(oldBackup, u.cfg.CurrentVersion); err != nil {
+		u.logf("keep backup: %v", err)
+	}
+	if err := u.recordInstall(chk.RemoteVersion, u.cfg.CurrentVersion); err != nil {
+		u.logf("record install: %v", err)
+	}
+	if err := j.done(); err != nil {
+		u.logf("journal: %v", err)
+	}
+
 	return &UpdateResult{
 		DidUpdate:     true,
 		OldBackupPath: oldBackup,
 		NewBinaryPath: curPath,
 		RemoteVersion: chk.RemoteVersion,
+		MirrorURL:     mirror,
+		Patched:       patched,
+		Rollout:       chk.Rollout,
 	}, nil
 }
 
+// fetchFull downloads the full artifact into newPath, extracting the
+// executable when the artifact is an archive.
+func (u *Updater) fetchFull(ctx context.Context, a *Artifact, format, newPath string) (string, error) {
+	// archives are staged next to newPath, the executable is extracted later
+	dlPath := newPath
+	if format != "" {
+		dlPath = newPath + ".archive"
+	}
+
+	// Download to staging (tries mirrors, verifies SHA256)
+	mirror, err := u.fetchArtifact(ctx, a, dlPath, u.cfg.MinBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// without a sha256 the signature was already checked per download
+	if a.SHA256 != "" {
+		if err := u.verifyArtifactSignature(ctx, a, dlPath); err != nil {
+			return "", err
+		}
+	}
+
+	if format != "" {
+		entry := a.Path
+		if entry == "" {
+			entry = u.cfg.ExeName
+		}
+		if err := util.ExtractFile(dlPath, format, entry, newPath, u.cfg.MaxExtractBytes); err != nil {
+			return "", fmt.Errorf("extract %s: %w", entry, err)
+		}
+		_ = util.RemoveWithRetry(dlPath, 5, 100*time.Millisecond)
+		u.logf("extracted %s to: %s", entry, newPath)
+	}
+	return mirror, nil
+}
+
+func (u *Updater) verifyArtifactSignature(ctx context.Context, a *Artifact, path string) error {
+	if u.cfg.ArtifactVerifier == nil {
+		return nil
+	}
+
+	raw := []byte(a.Signature)
+	if a.Signature == "" && a.SignatureURL != "" {
+		var b []byte
+		err := u.retryPolicy().Do(ctx, func(int) error {
+			var err error
+			b, err = util.FetchBytesClient(ctx, u.cfg.HTTPClient, a.SignatureURL, u.cfg.UserAgent, 4096)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("fetch artifact signature: %w", err)
+		}
+		raw = b
+	}
+	if len(raw) == 0 {
+		if u.cfg.RequireArtifactSignature {
+			return errors.New("artifact signature required but manifest has none")
+		}
+		u.logf("artifact is not signed; skipping signature check")
+		return nil
+	}
+
+	sig, err := verify.DecodeSignature(raw)
+	if err != nil {
+		return err
+	}
+	if err := verify.VerifyFileSignature(path, sig, u.cfg.ArtifactVerifier); err != nil {
+		return fmt.Errorf("artifact signature: %w", err)
+	}
+	u.logf("signature verified")
+	return nil
+}
+
 // Helper: convenience update with a hard deadline
 func (u *Updater) UpdateWithTimeout(timeout time.Duration) (*UpdateResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -0,0 +1,192 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blitzh/go-autoupdater/pkg/service"
+	"github.com/blitzh/go-autoupdater/pkg/util"
+	"github.com/blitzh/go-autoupdater/pkg/verify"
+)
+
+type staticSource struct{ m Manifest }
+
+func (s staticSource) Fetch(context.Context) (*Manifest, error) {
+	m := s.m
+	return &m, nil
+}
+
+// countingApplier is renameApplier counting how often it ran.
+type countingApplier struct {
+	renameApplier
+	applies int
+}
+
+func (a *countingApplier) Apply(ctx context.Context, svc service.Controller, currentPath, newPath, oldPath string) (string, error) {
+	a.applies++
+	return a.renameApplier.Apply(ctx, svc, currentPath, newPath, oldPath)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestFor is a manifest offering art as 2.0.0 for this platform.
+func manifestFor(art Artifact) Manifest {
+	art.OS, art.Arch = runtime.GOOS, runtime.GOARCH
+	return Manifest{Version: "2.0.0", Artifacts: []Artifact{art}}
+}
+
+func TestUpdateArtifactSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(nextBin)))
+	bad := base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, []byte(nextBin)))
+
+	var sigFetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agent", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(nextBin)) })
+	mux.HandleFunc("/agent.sig", func(w http.ResponseWriter, r *http.Request) {
+		sigFetches.Add(1)
+		_, _ = w.Write([]byte(good))
+	})
+	mux.HandleFunc("/agent.badsig", func(w http.ResponseWriter, r *http.Request) {
+		sigFetches.Add(1)
+		_, _ = w.Write([]byte(bad))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sum := sha256Hex(nextBin)
+	tests := []struct {
+		name           string
+		art            Artifact
+		noVerifier     bool
+		require        bool
+		wantErr        bool
+		wantSigFetches int32
+	}{
+		{name: "inline signature", art: Artifact{SHA256: sum, Signature: good}},
+		{name: "signature_url is fetched", art: Artifact{SHA256: sum, SignatureURL: srv.URL + "/agent.sig"}, wantSigFetches: 1},
+		{name: "signature instead of sha256", art: Artifact{SignatureURL: srv.URL + "/agent.sig"}, require: true, wantSigFetches: 1},
+		{name: "bad inline signature", art: Artifact{SHA256: sum, Signature: bad}, wantErr: true},
+		{name: "bad signature_url", art: Artifact{SHA256: sum, SignatureURL: srv.URL + "/agent.badsig"}, wantErr: true, wantSigFetches: 1},
+		{name: "missing signature_url", art: Artifact{SHA256: sum, SignatureURL: srv.URL + "/missing.sig"}, wantErr: true},
+		{name: "unsigned, not required", art: Artifact{SHA256: sum}},
+		{name: "required but unsigned", art: Artifact{SHA256: sum}, require: true, wantErr: true},
+		{name: "required without a verifier", art: Artifact{SHA256: sum, Signature: good}, require: true, noVerifier: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sigFetches.Store(0)
+			tt.art.URL = srv.URL + "/agent"
+			dir := install(t, map[string]string{"agent": prevBin})
+			ap := &countingApplier{}
+			cfg := Config{
+				CurrentVersion:           "1.0.0",
+				InstallDir:               dir,
+				ExeName:                  "agent",
+				Source:                   staticSource{manifestFor(tt.art)},
+				Applier:                  ap,
+				MinBytes:                 1,
+				Retry:                    &util.RetryPolicy{MaxAttempts: 1},
+				RequireArtifactSignature: tt.require,
+				BackupKeep:               -1,
+			}
+			if !tt.noVerifier {
+				cfg.ArtifactVerifier = verify.NewEd25519Verifier(pub)
+			}
+
+			res, err := New(cfg).Update(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", res)
+				}
+				if ap.applies != 0 {
+					t.Fatal("applier ran for a rejected artifact")
+				}
+				if got := readFile(t, filepath.Join(dir, "agent")); got != prevBin {
+					t.Fatalf("current binary = %q, want it untouched", got)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !res.DidUpdate || ap.applies != 1 {
+					t.Fatalf("result = %+v after %d applies", res, ap.applies)
+				}
+				if got := readFile(t, filepath.Join(dir, "agent")); got != nextBin {
+					t.Fatalf("current binary = %q, want the update", got)
+				}
+			}
+			if got := sigFetches.Load(); got != tt.wantSigFetches {
+				t.Errorf("signature fetched %d times, want %d", got, tt.wantSigFetches)
+			}
+		})
+	}
+}
+
+func TestUpdateFromLocalFile(t *testing.T) {
+	share := install(t, map[string]string{"agent_2.0.0": nextBin})
+	fileURL := util.FileURL(filepath.Join(share, "agent_2.0.0"))
+
+	tests := []struct {
+		name    string
+		art     Artifact
+		wantErr bool
+	}{
+		{"verified copy", Artifact{URL: fileURL, SHA256: sha256Hex(nextBin), Size: int64(len(nextBin))}, false},
+		{"sha256 mismatch", Artifact{URL: fileURL, SHA256: sha256Hex("something else")}, true},
+		{"size mismatch", Artifact{URL: fileURL, SHA256: sha256Hex(nextBin), Size: int64(len(nextBin)) + 1}, true},
+		{"missing file", Artifact{URL: fileURL + ".missing", SHA256: sha256Hex(nextBin)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := install(t, map[string]string{"agent": prevBin})
+			ap := &countingApplier{}
+			u := New(Config{
+				CurrentVersion: "1.0.0",
+				InstallDir:     dir,
+				ExeName:        "agent",
+				Source:         staticSource{manifestFor(tt.art)},
+				Applier:        ap,
+				MinBytes:       1,
+				Retry:          &util.RetryPolicy{MaxAttempts: 1},
+				BackupKeep:     -1,
+			})
+			res, err := u.Update(context.Background())
+			if tt.wantErr {
+				if err == nil || ap.applies != 0 {
+					t.Fatalf("got %+v, %v after %d applies; want an error before apply", res, err, ap.applies)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.MirrorURL != fileURL || readFile(t, filepath.Join(dir, "agent")) != nextBin {
+				t.Fatalf("result = %+v", res)
+			}
+			// the source file is copied, not moved
+			if readFile(t, filepath.Join(share, "agent_2.0.0")) != nextBin {
+				t.Fatal("source file changed")
+			}
+		})
+	}
+}
@@ -5,8 +5,92 @@ import (
 	"strings"
 )
 
+// VersionComparer orders two version strings: -1 if a<b, 0 if equal, +1 if
+// a>b. Set Config.VersionComparer to plug in CalVer or custom schemes.
+type VersionComparer interface {
+	Compare(a, b string) (int, error)
+}
+
+type VersionCompareFunc func(a, b string) (int, error)
+
+func (f VersionCompareFunc) Compare(a, b string) (int, error) { return f(a, b) }
+
+// SemVerComparer requires both versions to be valid SemVer 2.0.0.
+type SemVerComparer struct{}
+
+func (SemVerComparer) Compare(a, b string) (int, error) {
+	va, err := ParseSemVer(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseSemVer(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// LooseVersionComparer is CompareVersion; it never fails. Default comparer.
+type LooseVersionComparer struct{}
+
+func (LooseVersionComparer) Compare(a, b string) (int, error) { return CompareVersion(a, b), nil }
+
 func CompareVersion(a, b string) int {
 	// returns: -1 if a<b, 0 if equal, +1 if a>b
+	// SemVer precedence, but tolerant of "v1.2", "1.2.3.4" and leading zeros
+	ca, pa, okA := parseLooseVersion(a)
+	cb, pb, okB := parseLooseVersion(b)
+	if !okA || !okB {
+		return compareLegacy(a, b)
+	}
+
+	n := len(ca)
+	if len(cb) > n {
+		n = len(cb)
+	}
+	for i := 0; i < n; i++ {
+		var ai, bi uint64
+		if i < len(ca) {
+			ai = ca[i]
+		}
+		if i < len(cb) {
+			bi = cb[i]
+		}
+		if c := cmpUint(ai, bi); c != 0 {
+			return c
+		}
+	}
+	return comparePrerelease(pa, pb)
+}
+
+// parseLooseVersion splits v into numeric core and prerelease identifiers,
+// dropping build metadata. ok is false when the core is not all numeric.
+func parseLooseVersion(v string) (core []uint64, pre []string, ok bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		if v[i+1:] == "" {
+			return nil, nil, false
+		}
+		pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, nil, false
+		}
+		core = append(core, n)
+	}
+	return core, pre, true
+}
+
+// compareLegacy is the pre-SemVer ordering, kept for versions that are not
+// even loosely numeric.
+func compareLegacy(a, b string) int {
 	pa := parseVersion(a)
 	pb := parseVersion(b)
 
@@ -0,0 +1,167 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// NormalizeArchiveFormat maps accepted spellings to ArchiveTarGz/ArchiveZip,
+// "" for a raw file, or returns an error for anything else.
+func NormalizeArchiveFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), ".")) {
+	case "", "raw", "binary":
+		return "", nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	case "zip":
+		return ArchiveZip, nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", format)
+}
+
+// ExtractFile copies the regular file named entry out of the archive into
+// dst (via dst.part). Entry names that are absolute or climb out of the
+// archive root are rejected, as is an entry larger than maxBytes.
+func ExtractFile(archivePath, format, entry, dst string, maxBytes int64) error {
+	format, err := NormalizeArchiveFormat(format)
+	if err != nil {
+		return err
+	}
+	want, err := cleanEntryName(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".part"
+	_ = os.Remove(tmp)
+	switch format {
+	case ArchiveTarGz:
+		err = extractTarGz(archivePath, want, tmp, maxBytes)
+	case ArchiveZip:
+		err = extractZip(archivePath, want, tmp, maxBytes)
+	default:
+		err = errors.New("not an archive")
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return RenameWithRetry(tmp, dst, 30, 250*time.Millisecond)
+}
+
+func extractTarGz(archivePath, want, tmp string, maxBytes int64) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("archive has no entry %q", want)
+		}
+		if err != nil {
+			return err
+		}
+		name, err := cleanEntryName(h.Name)
+		if err != nil {
+			return err
+		}
+		if name != want {
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			return fmt.Errorf("archive entry %q is not a regular file", want)
+		}
+		if maxBytes > 0 && h.Size > maxBytes {
+			return fmt.Errorf("archive entry %q too large: %d bytes", want, h.Size)
+		}
+		return writeLimited(tmp, tr, maxBytes)
+	}
+}
+
+func extractZip(archivePath, want, tmp string, maxBytes int64) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		name, err := cleanEntryName(zf.Name)
+		if err != nil {
+			return err
+		}
+		if name != want {
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			return fmt.Errorf("archive entry %q is not a regular file", want)
+		}
+		if maxBytes > 0 && zf.UncompressedSize64 > uint64(maxBytes) {
+			return fmt.Errorf("archive entry %q too large: %d bytes", want, zf.UncompressedSize64)
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeLimited(tmp, rc, maxBytes)
+	}
+	return fmt.Errorf("archive has no entry %q", want)
+}
+
+// writeLimited enforces maxBytes on the actual stream, not just the header.
+func writeLimited(dst string, r io.Reader, maxBytes int64) error {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	n, err := io.Copy(out, r)
+	if err != nil {
+		return err
+	}
+	if maxBytes > 0 && n > maxBytes {
+		return fmt.Errorf("archive entry exceeds %d bytes", maxBytes)
+	}
+	return out.Sync()
+}
+
+func cleanEntryName(name string) (string, error) {
+	n := strings.ReplaceAll(name, "\\", "/")
+	if n == "" || strings.HasPrefix(n, "/") || filepath.IsAbs(name) || (len(n) > 1 && n[1] == ':') {
+		return "", fmt.Errorf("unsafe archive entry name %q", name)
+	}
+	n = path.Clean(n)
+	if n == ".." || strings.HasPrefix(n, "../") {
+		return "", fmt.Errorf("unsafe archive entry name %q", name)
+	}
+	return strings.TrimPrefix(n, "./"), nil
+}
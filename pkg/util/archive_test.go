@@ -0,0 +1,167 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name string
+	body string
+	link bool // symlink (tar) / symlink mode (zip) instead of a regular file
+}
+
+func writeTarGz(t *testing.T, path string, entries ...entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0755, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, "/bin/sh", 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if !e.link {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, entries ...entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetMode(0755)
+		if e.link {
+			h.SetMode(os.ModeSymlink | 0777)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []entry
+		want     string // entry to extract
+		maxBytes int64
+		wantBody string // "" = error expected
+	}{
+		{"top level", []entry{{name: "README"}, {name: "agent", body: "binary"}}, "agent", 0, "binary"},
+		{"nested", []entry{{name: "agent_1.2.3_linux_amd64/agent", body: "binary"}}, "agent_1.2.3_linux_amd64/agent", 0, "binary"},
+		{"dot slash prefix", []entry{{name: "./agent", body: "binary"}}, "agent", 0, "binary"},
+		{"backslash entry", []entry{{name: `dir\agent`, body: "binary"}}, "dir/agent", 0, "binary"},
+		{"exactly the limit", []entry{{name: "agent", body: "12345"}}, "agent", 5, "12345"},
+		{"over the limit", []entry{{name: "agent", body: "123456"}}, "agent", 5, ""},
+		{"missing", []entry{{name: "other", body: "x"}}, "agent", 0, ""},
+		{"symlink", []entry{{name: "agent", link: true}}, "agent", 0, ""},
+		{"traversal entry", []entry{{name: "../../etc/cron.d/evil", body: "x"}, {name: "agent", body: "binary"}}, "agent", 0, ""},
+		{"absolute entry", []entry{{name: "/etc/passwd", body: "x"}, {name: "agent", body: "binary"}}, "agent", 0, ""},
+		{"drive letter entry", []entry{{name: `C:\Windows\evil.exe`, body: "x"}, {name: "agent", body: "binary"}}, "agent", 0, ""},
+		{"climbing back out", []entry{{name: "a/../../agent", body: "x"}}, "agent", 0, ""},
+		{"traversal wanted", []entry{{name: "agent", body: "binary"}}, "../agent", 0, ""},
+		{"absolute wanted", []entry{{name: "agent", body: "binary"}}, "/agent", 0, ""},
+	}
+	formats := []struct {
+		format string
+		write  func(*testing.T, string, ...entry)
+	}{{ArchiveTarGz, writeTarGz}, {ArchiveZip, writeZip}}
+
+	for _, f := range formats {
+		for _, tt := range tests {
+			t.Run(f.format+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archive := filepath.Join(dir, "artifact."+f.format)
+				dst := filepath.Join(dir, "agent.new")
+				f.write(t, archive, tt.entries...)
+
+				err := ExtractFile(archive, f.format, tt.want, dst, tt.maxBytes)
+				if tt.wantBody == "" {
+					if err == nil {
+						t.Fatal("want error")
+					}
+					for _, p := range []string{dst, dst + ".part"} {
+						if _, err := os.Stat(p); !os.IsNotExist(err) {
+							t.Errorf("%s left behind", filepath.Base(p))
+						}
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := os.ReadFile(dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != tt.wantBody {
+					t.Fatalf("extracted %q, want %q", b, tt.wantBody)
+				}
+			})
+		}
+	}
+}
+
+func TestWriteLimitedChecksStream(t *testing.T) {
+	// the header size may lie; the copied bytes decide
+	dst := filepath.Join(t.TempDir(), "out")
+	if err := writeLimited(dst, strings.NewReader(strings.Repeat("x", 11)), 10); err == nil {
+		t.Fatal("want error for 11 bytes with a 10 byte limit")
+	}
+	if err := writeLimited(dst, strings.NewReader(strings.Repeat("x", 10)), 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalizeArchiveFormat(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""}, {"raw", ""}, {"binary", ""},
+		{"tar.gz", ArchiveTarGz}, {".tgz", ArchiveTarGz}, {" TAR.GZ ", ArchiveTarGz},
+		{"zip", ArchiveZip}, {".ZIP", ArchiveZip},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeArchiveFormat(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeArchiveFormat(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"tar", "tar.xz", "7z", "rar"} {
+		if _, err := NormalizeArchiveFormat(in); err == nil {
+			t.Errorf("NormalizeArchiveFormat(%q): want error", in)
+		}
+	}
+}
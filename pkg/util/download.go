@@ -0,0 +1,347 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Downloader fetches a URL into a staging file. A partial download is kept
+// as <dst>.part and resumed with Range/If-Range on the next call; dst only
+// appears once the whole file is present.
+type Downloader struct {
+	UserAgent string
+	MinBytes  int64
+
+	// ExpectedSize, when > 0, is the exact size the file must have
+	// (e.g. from the manifest); otherwise the server's length is used.
+	ExpectedSize int64
+
+	// Client defaults to one without an overall timeout; the context bounds
+	// the whole download and StallTimeout bounds periods without progress.
+	Client       *http.Client
+	StallTimeout time.Duration
+
+	// Retry resumes from the .part file after transient failures.
+	Retry *RetryPolicy
+
+	// Progress, if set, is called periodically and once with Done=true,
+	// with Err set if the transfer failed.
+	Progress func(Progress)
+}
+
+// partMeta is stored next to the .part file so a resume can prove it is
+// continuing the same remote file.
+type partMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+}
+
+var defaultDownloadClient = &http.Client{Transport: NewDownloadTransport()}
+
+// NewDownloadTransport is http.DefaultTransport with the response header
+// timeout of the client Downloader uses when Client is nil. Wrap it when
+// setting a custom Client to keep that timeout.
+func NewDownloadTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 60 * time.Second
+	return t
+}
+
+// Download also accepts file:// URLs; local files are copied, not resumed.
+func (d Downloader) Download(ctx context.Context, url, dst string) error {
+	if path, ok := LocalPath(url); ok {
+		return d.copyLocal(ctx, url, path, dst)
+	}
+	return d.Retry.Do(ctx, func(int) error {
+		return d.download(ctx, url, dst)
+	})
+}
+
+func (d Downloader) download(ctx context.Context, url, dst string) error {
+	if d.StallTimeout <= 0 {
+		d.StallTimeout = 60 * time.Second
+	}
+	if d.Client == nil {
+		d.Client = defaultDownloadClient
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return Permanent(err)
+	}
+
+	tmp := dst + ".part"
+	metaPath := tmp + ".json"
+
+	have := d.resumeOffset(url, tmp, metaPath)
+	if d.ExpectedSize > 0 && have == d.ExpectedSize {
+		return d.finishResumed(url, tmp, metaPath, dst, have)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := d.get(ctx, url, have, metaPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var total int64 = -1
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != have {
+			d.reset(tmp, metaPath)
+			return fmt.Errorf("download: unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), have)
+		}
+		total = size
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part file may already be complete
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == have {
+			return d.finishResumed(url, tmp, metaPath, dst, have)
+		}
+		d.reset(tmp, metaPath)
+		return fmt.Errorf("download: range not satisfiable at offset %d; partial file discarded", have)
+	default:
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return NewHTTPStatusError("download", resp)
+		}
+		// full body: server ignored the range or the validator changed
+		have = 0
+		total = resp.ContentLength
+		flags |= os.O_TRUNC
+	}
+
+	if d.ExpectedSize > 0 && total >= 0 && total != d.ExpectedSize {
+		return Permanent(fmt.Errorf("download: server size %d does not match expected %d", total, d.ExpectedSize))
+	}
+
+	meta := partMeta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         total,
+	}
+	if err := writePartMeta(metaPath, meta); err != nil {
+		return Permanent(err)
+	}
+
+	f, err := os.OpenFile(tmp, flags, 0644)
+	if err != nil {
+		return Permanent(err)
+	}
+	var body io.Reader = newStallReader(resp.Body, d.StallTimeout, cancel)
+	var pr *progressReader
+	if d.Progress != nil {
+		if total < 0 && d.ExpectedSize > 0 {
+			total = d.ExpectedSize
+		}
+		pr = newProgressReader(body, d.Progress, url, have, total)
+		body = pr
+	}
+	n, err := io.Copy(f, body)
+	_ = f.Sync()
+	_ = f.Close()
+	switch got := have + n; {
+	case err != nil:
+		// keep the part file for the next attempt
+		err = fmt.Errorf("download interrupted at %d bytes: %w", got, err)
+	case total >= 0 && got != total:
+		err = fmt.Errorf("download incomplete: %d of %d bytes", got, total)
+	default:
+		err = d.finish(tmp, metaPath, dst, got)
+	}
+	if pr != nil {
+		if err != nil {
+			pr.fail(err)
+		} else {
+			pr.finish()
+		}
+	}
+	return err
+}
+
+// resumeOffset returns how many bytes of tmp can be reused, discarding the
+// part file when it cannot be safely resumed.
+func (d Downloader) resumeOffset(url, tmp, metaPath string) int64 {
+	st, err := os.Stat(tmp)
+	if err != nil || st.Size() == 0 {
+		d.reset(tmp, metaPath)
+		return 0
+	}
+	meta, err := readPartMeta(metaPath)
+	if err != nil || meta.URL != url || (ifRangeValue(meta) == "") {
+		d.reset(tmp, metaPath)
+		return 0
+	}
+	if d.ExpectedSize > 0 && st.Size() > d.ExpectedSize {
+		d.reset(tmp, metaPath)
+		return 0
+	}
+	return st.Size()
+}
+
+func (d Downloader) get(ctx context.Context, url string, offset int64, metaPath string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	if d.UserAgent != "" {
+		req.Header.Set("User-Agent", d.UserAgent)
+	}
+	if offset > 0 {
+		meta, _ := readPartMeta(metaPath)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", ifRangeValue(meta))
+	}
+	return d.Client.Do(req)
+}
+
+func (d Downloader) finish(tmp, metaPath, dst string, n int64) error {
+	if d.ExpectedSize > 0 && n != d.ExpectedSize {
+		d.reset(tmp, metaPath)
+		return Permanent(fmt.Errorf("downloaded size %d does not match expected %d", n, d.ExpectedSize))
+	}
+	if d.MinBytes > 0 && n < d.MinBytes {
+		d.reset(tmp, metaPath)
+		return Permanent(fmt.Errorf("downloaded file too small: %d bytes", n))
+	}
+	if err := RenameWithRetry(tmp, dst, 30, 250*time.Millisecond); err != nil {
+		return Permanent(err)
+	}
+	_ = os.Remove(metaPath)
+	return nil
+}
+
+// finishResumed completes a part file that turned out to be whole already.
+func (d Downloader) finishResumed(url, tmp, metaPath, dst string, n int64) error {
+	if err := d.finish(tmp, metaPath, dst, n); err != nil {
+		return err
+	}
+	if d.Progress != nil {
+		d.Progress(Progress{URL: url, Downloaded: n, Total: n, Done: true})
+	}
+	return nil
+}
+
+func (d Downloader) reset(tmp, metaPath string) {
+	_ = os.Remove(tmp)
+	_ = os.Remove(metaPath)
+}
+
+// ifRangeValue prefers a strong ETag; weak ETags may not be used in If-Range.
+func ifRangeValue(m *partMeta) string {
+	if m == nil {
+		return ""
+	}
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+func readPartMeta(path string) (*partMeta, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m partMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func writePartMeta(path string, m partMeta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// parseContentRange parses "bytes 100-199/200" and "bytes */200".
+// size is -1 when the server sends "*" for the total.
+func parseContentRange(v string) (start, size int64, ok bool) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, false
+	}
+	rng, total, found := strings.Cut(strings.TrimPrefix(v, "bytes "), "/")
+	if !found {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		n, err := strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		size = n
+	}
+	if rng == "*" {
+		return 0, size, true
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+var errStalled = errors.New("download stalled")
+
+// stallReader cancels the request when no data arrives for timeout.
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+
+	mu      sync.Mutex
+	stalled bool
+}
+
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		s.stalled = true
+		s.mu.Unlock()
+		cancel()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	if err != nil {
+		s.timer.Stop()
+		s.mu.Lock()
+		stalled := s.stalled
+		s.mu.Unlock()
+		if stalled && err != io.EOF {
+			return n, errStalled
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// servedFile serves body with ETag etag, honouring Range and If-Range, and
+// records the Range and If-Range headers of each request.
+type servedFile struct {
+	body        []byte
+	etag        string
+	ignoreRange bool
+
+	mu       sync.Mutex
+	requests []http.Header
+}
+
+func (f *servedFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Header.Clone())
+	f.mu.Unlock()
+	w.Header().Set("ETag", f.etag)
+	if f.ignoreRange {
+		_, _ = w.Write(f.body)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.body))
+}
+
+func (f *servedFile) lastRequest() http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func testBody() []byte {
+	b := make([]byte, 100_000)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// writePart leaves dst.part and its metadata as an interrupted download would.
+func writePart(t *testing.T, dst string, part []byte, meta partMeta) {
+	t.Helper()
+	if err := os.WriteFile(dst+".part", part, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePartMeta(dst+".part.json", meta); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloaderResume(t *testing.T) {
+	body := testBody()
+	const half = 40_000
+
+	tests := []struct {
+		name        string
+		etag        string // served
+		ignoreRange bool
+		part        []byte
+		partETag    string
+		expected    int64
+
+		wantRange string // Range header sent
+		wantErr   bool
+	}{
+		{name: "fresh download", etag: `"v1"`},
+		{name: "resume", etag: `"v1"`, part: body[:half], partETag: `"v1"`, wantRange: "bytes=40000-"},
+		{name: "resume with expected size", etag: `"v1"`, part: body[:half], partETag: `"v1"`, expected: int64(len(body)), wantRange: "bytes=40000-"},
+		{name: "changed etag restarts from zero", etag: `"v2"`, part: []byte("stale bytes of an older build"), partETag: `"v1"`, wantRange: "bytes=29-"},
+		{name: "server ignores range", etag: `"v1"`, ignoreRange: true, part: body[:half], partETag: `"v1"`, wantRange: "bytes=40000-"},
+		{name: "part already complete (416)", etag: `"v1"`, part: body, partETag: `"v1"`, wantRange: "bytes=100000-"},
+		{name: "weak etag is not resumed", etag: `W/"v1"`, part: body[:half], partETag: `W/"v1"`},
+		{name: "size mismatch", etag: `"v1"`, expected: int64(len(body)) + 1, wantErr: true},
+		{name: "part larger than expected", etag: `"v1"`, part: append(append([]byte(nil), body...), 'x'), partETag: `"v1"`, expected: int64(len(body))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &servedFile{body: body, etag: tt.etag, ignoreRange: tt.ignoreRange}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+			dst := filepath.Join(t.TempDir(), "agent")
+			if tt.part != nil {
+				writePart(t, dst, tt.part, partMeta{URL: srv.URL, ETag: tt.partETag})
+			}
+
+			d := Downloader{ExpectedSize: tt.expected}
+			err := d.Download(context.Background(), srv.URL, dst)
+			if tt.wantErr {
+				var perm permanentError
+				if !errors.As(err, &perm) {
+					t.Fatalf("got %v, want a permanent error", err)
+				}
+				if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+					t.Fatal("dst written despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Fatalf("downloaded %d bytes, not the served file", len(got))
+			}
+			for _, name := range []string{dst + ".part", dst + ".part.json"} {
+				if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s left behind", filepath.Base(name))
+				}
+			}
+			h := f.lastRequest()
+			if got := h.Get("Range"); got != tt.wantRange {
+				t.Errorf("Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantRange != "" && h.Get("If-Range") != tt.partETag {
+				t.Errorf("If-Range = %q, want %q", h.Get("If-Range"), tt.partETag)
+			}
+		})
+	}
+}
+
+func TestDownloaderStall(t *testing.T) {
+	body := testBody()
+	const half = 40_000
+
+	var mu sync.Mutex
+	calls := 0
+	good := &servedFile{body: body, etag: `"v1"`}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if !first {
+			good.ServeHTTP(w, r)
+			return
+		}
+		// send half the file, then go quiet
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "100000")
+		_, _ = w.Write(body[:half])
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	dst := filepath.Join(t.TempDir(), "agent")
+
+	// one attempt: the stalled transfer is kept for the next run
+	var last Progress
+	d := Downloader{StallTimeout: 100 * time.Millisecond, Progress: func(p Progress) { last = p }}
+	if err := d.Download(context.Background(), srv.URL, dst); err == nil {
+		t.Fatal("want an error for a stalled download")
+	}
+	if !last.Done || last.Err == nil || last.Downloaded != half {
+		t.Fatalf("last progress = %+v, want Done with the error", last)
+	}
+	if st, err := os.Stat(dst + ".part"); err != nil || st.Size() != half {
+		t.Fatalf("part file after stall: %v, %v; want %d bytes", st, err, half)
+	}
+
+	if err := d.Download(context.Background(), srv.URL, dst); err != nil {
+		t.Fatal(err)
+	}
+	if !last.Done || last.Err != nil || last.Downloaded != int64(len(body)) {
+		t.Fatalf("last progress = %+v, want Done", last)
+	}
+	if got := good.lastRequest().Get("Range"); got != "bytes=40000-" {
+		t.Fatalf("Range = %q, want a resume", got)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, body) {
+		t.Fatal("resumed file differs from the served file")
+	}
+}
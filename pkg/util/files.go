@@ -12,49 +12,49 @@ import (
 )
 
 func DownloadToFile(ctx context.Context, url, dst string, userAgent string, minBytes int64) error {
-	tmp := dst + ".part"
-	_ = os.Remove(tmp)
+	return Downloader{UserAgent: userAgent, MinBytes: minBytes}.Download(ctx, url, dst)
+}
+
+// FetchBytes GETs a small document (e.g. a signature) into memory.
+// file:// URLs are read from disk.
+func FetchBytes(ctx context.Context, url, userAgent string, maxBytes int64) ([]byte, error) {
+	return FetchBytesClient(ctx, nil, url, userAgent, maxBytes)
+}
 
+// FetchBytesClient is FetchBytes with a custom client (nil: 30s timeout).
+func FetchBytesClient(ctx context.Context, client *http.Client, url, userAgent string, maxBytes int64) ([]byte, error) {
+	if path, ok := LocalPath(url); ok {
+		return readLocal(path, maxBytes)
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, Permanent(err)
 	}
 	if userAgent != "" {
 		req.Header.Set("User-Agent", userAgent)
 	}
 
-	client := &http.Client{Timeout: 90 * time.Second}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("download http status: %s", resp.Status)
+		return nil, NewHTTPStatusError("fetch", resp)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return err
-	}
-
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-
-	n, err := io.Copy(f, resp.Body)
-	if err != nil {
-		return err
-	}
-	_ = f.Sync()
-
-	if minBytes > 0 && n < minBytes {
-		return fmt.Errorf("downloaded file too small: %d bytes", n)
+	if int64(len(b)) > maxBytes {
+		return nil, Permanent(fmt.Errorf("response too large (> %d bytes)", maxBytes))
 	}
-
-	return RenameWithRetry(tmp, dst, 30, 250*time.Millisecond)
+	return b, nil
 }
 
 func RenameWithRetry(from, to string, retries int, delay time.Duration) error {
@@ -81,3 +81,70 @@ func RemoveWithRetry(path string, retries int, delay time.Duration) error {
 	}
 	return fmt.Errorf("remove failed: %w (path=%s)", last, path)
 }
+
+// CopyFile copies src to dst (via dst.tmp) keeping src's permission bits.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// WriteFileSync writes data to path via path.tmp, syncing the file and its
+// directory so the write survives a power loss.
+func WriteFileSync(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	SyncDir(filepath.Dir(path))
+	return nil
+}
+
+// SyncDir flushes a directory's entries (renames) to disk; best effort,
+// not supported on Windows.
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// LocalPath returns the filesystem path of a file:// URL.
+// file:///C:/dir/x and file://server/share/x (UNC) are understood on Windows.
+func LocalPath(rawURL string) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(rawURL), "file:") {
+		return "", false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" && u.Opaque == "" {
+		return "", false
+	}
+	p := u.Path
+	if p == "" {
+		p = u.Opaque // file:relative/path
+	}
+	if runtime.GOOS == "windows" {
+		if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+		if u.Host != "" && !strings.EqualFold(u.Host, "localhost") {
+			p = "//" + u.Host + p
+		}
+	}
+	return filepath.FromSlash(p), true
+}
+
+// FileURL converts an absolute path into a file:// URL.
+func FileURL(path string) string {
+	p := filepath.ToSlash(path)
+	if strings.HasPrefix(p, "//") {
+		// UNC: //server/share/x
+		return "file:" + p
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// copyLocal stages a local file exactly like a download: via dst.part,
+// with the same size checks, progress reports and final rename.
+func (d Downloader) copyLocal(ctx context.Context, rawURL, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return Permanent(err)
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return Permanent(err)
+	}
+	if !st.Mode().IsRegular() {
+		return Permanent(fmt.Errorf("copy: %s is not a regular file", src))
+	}
+	total := st.Size()
+	if d.ExpectedSize > 0 && total != d.ExpectedSize {
+		return Permanent(fmt.Errorf("copy: file size %d does not match expected %d", total, d.ExpectedSize))
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return Permanent(err)
+	}
+
+	tmp := dst + ".part"
+	metaPath := tmp + ".json"
+	d.reset(tmp, metaPath)
+
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return Permanent(err)
+	}
+	var r io.Reader = ctxReader{ctx: ctx, r: in}
+	var pr *progressReader
+	if d.Progress != nil {
+		pr = newProgressReader(r, d.Progress, rawURL, 0, total)
+		r = pr
+	}
+	n, err := io.Copy(out, r)
+	_ = out.Sync()
+	_ = out.Close()
+	switch {
+	case err != nil:
+		d.reset(tmp, metaPath)
+		err = fmt.Errorf("copy interrupted at %d bytes: %w", n, err)
+	case n != total:
+		d.reset(tmp, metaPath)
+		err = fmt.Errorf("copy incomplete: %d of %d bytes", n, total)
+	default:
+		err = d.finish(tmp, metaPath, dst, n)
+	}
+	if pr != nil {
+		if err != nil {
+			pr.fail(err)
+		} else {
+			pr.finish()
+		}
+	}
+	return err
+}
+
+// ctxReader stops a local copy when the context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func readLocal(path string, maxBytes int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBytes {
+		return nil, Permanent(fmt.Errorf("file too large (> %d bytes)", maxBytes))
+	}
+	return b, nil
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX paths")
+	}
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"file:///opt/agent/bin", "/opt/agent/bin", true},
+		{"FILE:///opt/agent/bin", "/opt/agent/bin", true},
+		{"file:///opt/a%20b/bin", "/opt/a b/bin", true},
+		{"file:bin/agent", "bin/agent", true},
+		{"file://", "", false},
+		{"https://example.com/agent", "", false},
+		{"/opt/agent/bin", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := LocalPath(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("LocalPath(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+	if p, _ := LocalPath(FileURL("/opt/a b/agent")); p != "/opt/a b/agent" {
+		t.Errorf("FileURL round trip = %q", p)
+	}
+}
+
+func TestCopyLocal(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "agent_1.2.0_linux_amd64")
+	body := testBody()
+	if err := os.WriteFile(src, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		d       Downloader
+		wantErr bool // permanently
+	}{
+		{name: "copy", src: src},
+		{name: "expected size", src: src, d: Downloader{ExpectedSize: int64(len(body))}},
+		{name: "size mismatch", src: src, d: Downloader{ExpectedSize: int64(len(body)) - 1}, wantErr: true},
+		{name: "too small", src: src, d: Downloader{MinBytes: int64(len(body)) + 1}, wantErr: true},
+		{name: "missing", src: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "directory", src: dir, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "staged", "agent.new")
+			var last Progress
+			tt.d.Progress = func(p Progress) { last = p }
+			err := tt.d.Download(context.Background(), FileURL(tt.src), dst)
+			if tt.wantErr {
+				var perm permanentError
+				if !errors.As(err, &perm) {
+					t.Fatalf("got %v, want a permanent error", err)
+				}
+				for _, name := range []string{dst, dst + ".part"} {
+					if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+						t.Errorf("%s left behind", filepath.Base(name))
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil || len(got) != len(body) {
+				t.Fatalf("staged %d bytes, %v", len(got), err)
+			}
+			if !last.Done || last.Err != nil || last.Downloaded != int64(len(body)) {
+				t.Errorf("last progress = %+v", last)
+			}
+		})
+	}
+
+	// a canceled copy keeps nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dst := filepath.Join(t.TempDir(), "agent.new")
+	if err := (Downloader{}).Download(ctx, FileURL(src), dst); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(dst + ".part"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("part file left behind")
+	}
+}
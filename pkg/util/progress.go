@@ -0,0 +1,74 @@
+package util
+
+import (
+	"io"
+	"time"
+)
+
+// Progress is reported periodically while a download runs.
+type Progress struct {
+	URL         string
+	Downloaded  int64 // bytes in the staging file, including resumed bytes
+	Total       int64 // -1 when unknown
+	BytesPerSec float64
+	ETA         time.Duration // 0 when unknown
+	Done        bool
+	Err         error // with Done: the download failed
+}
+
+// progressReader counts bytes read and reports at most once per interval.
+type progressReader struct {
+	r        io.Reader
+	fn       func(Progress)
+	interval time.Duration
+
+	p       Progress
+	start   time.Time
+	resumed int64
+	last    time.Time
+}
+
+func newProgressReader(r io.Reader, fn func(Progress), url string, have, total int64) *progressReader {
+	now := time.Now()
+	pr := &progressReader{
+		r:        r,
+		fn:       fn,
+		interval: 200 * time.Millisecond,
+		p:        Progress{URL: url, Downloaded: have, Total: total},
+		start:    now,
+		resumed:  have,
+	}
+	pr.report(now)
+	return pr
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.Downloaded += int64(n)
+	if now := time.Now(); now.Sub(pr.last) >= pr.interval {
+		pr.report(now)
+	}
+	return n, err
+}
+
+func (pr *progressReader) finish() {
+	pr.p.Done = true
+	pr.report(time.Now())
+}
+
+func (pr *progressReader) fail(err error) {
+	pr.p.Done, pr.p.Err = true, err
+	pr.report(time.Now())
+}
+
+func (pr *progressReader) report(now time.Time) {
+	pr.last = now
+	if el := now.Sub(pr.start).Seconds(); el > 0 {
+		pr.p.BytesPerSec = float64(pr.p.Downloaded-pr.resumed) / el
+	}
+	pr.p.ETA = 0
+	if pr.p.Total > 0 && pr.p.BytesPerSec > 0 && pr.p.Downloaded < pr.p.Total {
+		pr.p.ETA = time.Duration(float64(pr.p.Total-pr.p.Downloaded) / pr.p.BytesPerSec * float64(time.Second))
+	}
+	pr.fn(pr.p)
+}
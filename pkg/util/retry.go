@@ -0,0 +1,210 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy retries transient failures with exponential backoff and
+// jitter. A nil *RetryPolicy means a single attempt.
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first
+	InitialBackoff time.Duration // delay before the 2nd attempt
+	MaxBackoff     time.Duration // cap for computed delays (not Retry-After)
+	Multiplier     float64
+	Jitter         float64 // +/- fraction of each delay, 0..1
+
+	// RetryStatus decides which HTTP status codes are transient;
+	// default: 408, 429 and 5xx.
+	RetryStatus func(code int) bool
+
+	// OnRetry is called before sleeping, e.g. for logging.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// HTTPStatusError is a non-2xx response. RetryAfter is parsed from the
+// Retry-After header when present.
+type HTTPStatusError struct {
+	Op         string // "manifest", "download", ...
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s http status: %s", e.Op, e.Status)
+}
+
+// NewHTTPStatusError builds an HTTPStatusError from resp.
+func NewHTTPStatusError(op string, resp *http.Response) *HTTPStatusError {
+	return &HTTPStatusError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Do runs fn until it succeeds, fails permanently, runs out of attempts or
+// would have to sleep past the context deadline.
+func (p *RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	attempts := 1
+	if p != nil && p.MaxAttempts > 1 {
+		attempts = p.MaxAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !p.retryable(ctx, err) {
+			return err
+		}
+
+		delay := p.delay(attempt, err)
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < delay {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var perm permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *HTTPStatusError
+	if errors.As(err, &se) {
+		if p.RetryStatus != nil {
+			return p.RetryStatus(se.StatusCode)
+		}
+		return se.StatusCode == http.StatusRequestTimeout ||
+			se.StatusCode == http.StatusTooManyRequests ||
+			se.StatusCode >= 500
+	}
+	if permanentTransportError(err) {
+		return false
+	}
+	// network errors, resets, truncated bodies
+	return true
+}
+
+// permanentTransportError reports client errors that fail the same way on
+// every attempt: an untrusted or mismatched certificate, or a URL scheme the
+// client cannot fetch.
+func permanentTransportError(err error) bool {
+	var ue *url.Error
+	if !errors.As(err, &ue) || ue.Timeout() {
+		return false
+	}
+	var (
+		verr *tls.CertificateVerificationError
+		uerr x509.UnknownAuthorityError
+		herr x509.HostnameError
+		cerr x509.CertificateInvalidError
+	)
+	if errors.As(ue.Err, &verr) || errors.As(ue.Err, &uerr) || errors.As(ue.Err, &herr) || errors.As(ue.Err, &cerr) {
+		return true
+	}
+	// net/http has no typed error for this one
+	return strings.HasPrefix(ue.Err.Error(), "unsupported protocol scheme")
+}
+
+func (p *RetryPolicy) delay(attempt int, err error) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = time.Second
+	}
+	maxd := p.MaxBackoff
+	if maxd <= 0 {
+		maxd = 30 * time.Second
+	}
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+
+	d := float64(initial) * math.Pow(mult, float64(attempt-1))
+	if p.Jitter > 0 {
+		j := math.Min(p.Jitter, 1)
+		d *= 1 + j*(2*rand.Float64()-1)
+	}
+	delay := time.Duration(d)
+	if delay > maxd {
+		delay = maxd
+	}
+
+	// the server knows best; Do still gives up if this passes the deadline
+	var se *HTTPStatusError
+	if errors.As(err, &se) && se.RetryAfter > delay {
+		delay = se.RetryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter accepts delay-seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,205 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func fastPolicy(attempts int) *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: attempts, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func TestRetryable(t *testing.T) {
+	_, schemeErr := http.Get("ftp://example.com/agent")
+	urlErr := func(err error) error { return &url.Error{Op: "Get", URL: "https://example.com/agent", Err: err} }
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection reset by peer"), true},
+		{"dial error", urlErr(errors.New("dial tcp: connection refused")), true},
+		{"timeout", urlErr(timeoutError{}), true},
+		{"503", &HTTPStatusError{StatusCode: 503}, true},
+		{"429", fmt.Errorf("manifest: %w", &HTTPStatusError{StatusCode: 429}), true},
+		{"408", &HTTPStatusError{StatusCode: 408}, true},
+		{"404", &HTTPStatusError{StatusCode: 404}, false},
+		{"403", &HTTPStatusError{StatusCode: 403}, false},
+		{"permanent", fmt.Errorf("wrapped: %w", Permanent(errors.New("size mismatch"))), false},
+		{"canceled", fmt.Errorf("download: %w", context.Canceled), false},
+		{"unknown authority", urlErr(x509.UnknownAuthorityError{}), false},
+		{"hostname mismatch", urlErr(&tls.CertificateVerificationError{Err: x509.HostnameError{Host: "example.com"}}), false},
+		{"expired certificate", urlErr(x509.CertificateInvalidError{Reason: x509.Expired}), false},
+		{"unsupported scheme", schemeErr, false},
+	}
+	p := DefaultRetryPolicy()
+	for _, tt := range tests {
+		if got := p.retryable(context.Background(), tt.err); got != tt.want {
+			t.Errorf("%s: retryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+
+	custom := &RetryPolicy{RetryStatus: func(code int) bool { return code == 404 }}
+	if !custom.retryable(context.Background(), &HTTPStatusError{StatusCode: 404}) || custom.retryable(context.Background(), &HTTPStatusError{StatusCode: 503}) {
+		t.Error("RetryStatus not used")
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error // returned by successive attempts, then nil
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 1, false},
+		{"transient then success", []error{errors.New("reset"), &HTTPStatusError{StatusCode: 502}}, 3, false},
+		{"out of attempts", []error{errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d")}, 3, true},
+		{"permanent stops", []error{Permanent(errors.New("bad")), errors.New("b")}, 1, true},
+		{"404 stops", []error{&HTTPStatusError{StatusCode: 404}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := fastPolicy(3)
+			retries := 0
+			p.OnRetry = func(int, error, time.Duration) { retries++ }
+			attempts := 0
+			err := p.Do(context.Background(), func(attempt int) error {
+				attempts++
+				if attempt != attempts {
+					t.Fatalf("attempt = %d, want %d", attempt, attempts)
+				}
+				if attempt <= len(tt.errs) {
+					return tt.errs[attempt-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr || attempts != tt.wantAttempts || retries != attempts-1 {
+				t.Fatalf("err=%v after %d attempts (%d retries), want error=%v after %d", err, attempts, retries, tt.wantErr, tt.wantAttempts)
+			}
+		})
+	}
+
+	// a nil policy is a single attempt
+	attempts := 0
+	_ = (*RetryPolicy)(nil).Do(context.Background(), func(int) error { attempts++; return errors.New("reset") })
+	if attempts != 1 {
+		t.Fatalf("nil policy made %d attempts", attempts)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{"", 0},
+	} {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	// Retry-After overrides the backoff and its cap
+	p := fastPolicy(3)
+	if got := p.delay(1, &HTTPStatusError{StatusCode: 503, RetryAfter: time.Second}); got != time.Second {
+		t.Errorf("delay = %s, want the server's 1s", got)
+	}
+	if got := p.delay(1, &HTTPStatusError{StatusCode: 503}); got != time.Millisecond {
+		t.Errorf("delay = %s, want the backoff", got)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if se := NewHTTPStatusError("download", resp); se.RetryAfter != 7*time.Second || se.StatusCode != 503 {
+		t.Errorf("NewHTTPStatusError = %+v", se)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *RetryPolicy
+		err  error
+	}{
+		{"Retry-After past the deadline", fastPolicy(5), &HTTPStatusError{StatusCode: 503, RetryAfter: time.Hour}},
+		{"backoff past the deadline", &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}, errors.New("reset")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			attempts := 0
+			start := time.Now()
+			err := tt.p.Do(ctx, func(int) error { attempts++; return tt.err })
+			if err != tt.err || attempts != 1 {
+				t.Fatalf("err=%v after %d attempts, want the first error without retrying", err, attempts)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Fatalf("Do slept %s instead of giving up", d)
+			}
+		})
+	}
+}
+
+func TestRetryPermanentRequests(t *testing.T) {
+	// an untrusted certificate fails the same way every time
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	attempts := 0
+	err := fastPolicy(3).Do(context.Background(), func(int) error {
+		attempts++
+		resp, err := http.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("err=%v after %d attempts, want one failed attempt", err, attempts)
+	}
+
+	// so does a URL no request can be built for
+	d := Downloader{Retry: fastPolicy(3)}
+	err = d.Download(context.Background(), "http://[::1/agent", filepath.Join(t.TempDir(), "agent"))
+	var perm permanentError
+	if !errors.As(err, &perm) {
+		t.Fatalf("Download = %v, want a permanent error", err)
+	}
+}
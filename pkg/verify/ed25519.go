@@ -0,0 +1,117 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// SignatureVerifier checks a detached signature over message.
+type SignatureVerifier interface {
+	Verify(message, sig []byte) error
+}
+
+// Ed25519Verifier accepts a signature made by any of its keys.
+type Ed25519Verifier struct {
+	Keys []ed25519.PublicKey
+}
+
+func NewEd25519Verifier(keys ...ed25519.PublicKey) *Ed25519Verifier {
+	return &Ed25519Verifier{Keys: keys}
+}
+
+func (v *Ed25519Verifier) Verify(message, sig []byte) error {
+	if v == nil || len(v.Keys) == 0 {
+		return errors.New("no trusted ed25519 keys configured")
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("bad ed25519 signature size: %d", len(sig))
+	}
+	for _, k := range v.Keys {
+		if len(k) == ed25519.PublicKeySize && ed25519.Verify(k, message, sig) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// VerifyFileSignature checks sig over the full contents of path.
+func VerifyFileSignature(path string, sig []byte, v SignatureVerifier) error {
+	if v == nil {
+		return errors.New("signature verifier is nil")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return v.Verify(b, sig)
+}
+
+// ParseEd25519PublicKey accepts a PEM block, base64 PKIX DER, or the raw
+// 32-byte key encoded as base64 or hex, optionally prefixed with "ed25519:".
+func ParseEd25519PublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if blk, _ := pem.Decode([]byte(s)); blk != nil {
+		return parsePKIXEd25519(blk.Bytes)
+	}
+	s = strings.TrimPrefix(s, "ed25519:")
+	b, err := decodeKeyMaterial(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		if k, err := parsePKIXEd25519(b); err == nil {
+			return k, nil
+		}
+		return nil, fmt.Errorf("bad ed25519 public key size: %d", len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+func parsePKIXEd25519(der []byte) (ed25519.PublicKey, error) {
+	k, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pk, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not ed25519", k)
+	}
+	return pk, nil
+}
+
+// DecodeSignature accepts a raw 64-byte signature or its base64/hex text form.
+func DecodeSignature(b []byte) ([]byte, error) {
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	s := strings.TrimSpace(string(b))
+	sig, err := decodeKeyMaterial(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("bad ed25519 signature size: %d", len(sig))
+	}
+	return sig, nil
+}
+
+func decodeKeyMaterial(s string) ([]byte, error) {
+	if b, err := hex.DecodeString(s); err == nil {
+		return b, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("not valid base64 or hex")
+}
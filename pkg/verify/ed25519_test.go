@@ -0,0 +1,165 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestParseEd25519PublicKey(t *testing.T) {
+	pub, _ := newKey(t)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{"base64", base64.StdEncoding.EncodeToString(pub), false},
+		{"raw base64", base64.RawStdEncoding.EncodeToString(pub), false},
+		{"url base64", base64.URLEncoding.EncodeToString(pub), false},
+		{"hex", hex.EncodeToString(pub), false},
+		{"prefixed", "ed25519:" + base64.StdEncoding.EncodeToString(pub), false},
+		{"whitespace", "  " + hex.EncodeToString(pub) + "\n", false},
+		{"pkix der", base64.StdEncoding.EncodeToString(der), false},
+		{"pem", pemKey, false},
+		{"short", base64.StdEncoding.EncodeToString(pub[:16]), true},
+		{"garbage", "not a key!", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEd25519PublicKey(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got key %x, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(pub) {
+				t.Fatalf("got %x, want %x", got, pub)
+			}
+		})
+	}
+}
+
+func TestDecodeSignature(t *testing.T) {
+	_, priv := newKey(t)
+	sig := ed25519.Sign(priv, []byte("msg"))
+
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr bool
+	}{
+		{"raw", sig, false},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(sig)), false},
+		{"base64 newline", []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), false},
+		{"hex", []byte(hex.EncodeToString(sig)), false},
+		{"short", []byte(base64.StdEncoding.EncodeToString(sig[:32])), true},
+		{"garbage", []byte("???"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSignature(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(sig) {
+				t.Fatal("decoded signature differs")
+			}
+		})
+	}
+}
+
+func TestEd25519Verifier(t *testing.T) {
+	pub, priv := newKey(t)
+	other, otherPriv := newKey(t)
+	msg := []byte(`{"version":"1.0.0"}`)
+	sig := ed25519.Sign(priv, msg)
+
+	tests := []struct {
+		name string
+		v    *Ed25519Verifier
+		msg  []byte
+		sig  []byte
+		want error // nil, ErrSignatureInvalid, or errAny
+	}{
+		{"valid", NewEd25519Verifier(pub), msg, sig, nil},
+		{"any of several keys", NewEd25519Verifier(other, pub), msg, sig, nil},
+		{"tampered message", NewEd25519Verifier(pub), []byte(`{"version":"9.0.0"}`), sig, ErrSignatureInvalid},
+		{"other signer", NewEd25519Verifier(pub), msg, ed25519.Sign(otherPriv, msg), ErrSignatureInvalid},
+		{"untrusted key", NewEd25519Verifier(other), msg, sig, ErrSignatureInvalid},
+		{"truncated signature", NewEd25519Verifier(pub), msg, sig[:63], errAny},
+		{"no keys", NewEd25519Verifier(), msg, sig, errAny},
+		{"nil verifier", nil, msg, sig, errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, tt.v.Verify(tt.msg, tt.sig), tt.want)
+		})
+	}
+}
+
+func TestVerifyFileSignature(t *testing.T) {
+	pub, priv := newKey(t)
+	path := filepath.Join(t.TempDir(), "agent")
+	data := []byte("binary contents")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := NewEd25519Verifier(pub)
+
+	if err := VerifyFileSignature(path, ed25519.Sign(priv, data), v); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFileSignature(path, ed25519.Sign(priv, []byte("other")), v); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("got %v, want ErrSignatureInvalid", err)
+	}
+	if err := VerifyFileSignature(path, ed25519.Sign(priv, data), nil); err == nil {
+		t.Fatal("nil verifier: want error")
+	}
+}
+
+// errAny matches any non-nil error in checkErr.
+var errAny = errors.New("any error")
+
+func checkErr(t *testing.T, got, want error) {
+	t.Helper()
+	switch {
+	case want == nil && got != nil:
+		t.Fatalf("unexpected error: %v", got)
+	case want == errAny && got == nil:
+		t.Fatal("want an error")
+	case want != nil && want != errAny && !errors.Is(got, want):
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,325 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeyedVerifier checks a signature made by one specific key.
+type KeyedVerifier interface {
+	VerifyKey(keyID string, message, sig []byte) error
+}
+
+// TrustedKey is one entry of a KeyRing. Zero NotBefore/NotAfter mean no bound.
+type TrustedKey struct {
+	ID        string            `json:"id"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	NotBefore time.Time         `json:"not_before,omitempty"`
+	NotAfter  time.Time         `json:"not_after,omitempty"`
+	Revoked   bool              `json:"revoked,omitempty"`
+}
+
+func (k TrustedKey) validAt(t time.Time) bool {
+	if k.Revoked || len(k.PublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && !t.Before(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// KeyID derives a short stable id from a public key (first 8 bytes of its
+// SHA256, hex). Used when a TrustedKey has no explicit ID.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RotationDoc adds and revokes keys. Version starts at 1 and must increase
+// with every published document so old ones cannot be replayed.
+type RotationDoc struct {
+	Version int64        `json:"version"`
+	Keys    []TrustedKey `json:"keys"`
+	Revoke  []string     `json:"revoke,omitempty"`
+}
+
+// SignedRotation is the wire form of a RotationDoc. Signed holds the
+// RotationDoc JSON exactly as signed; at least one signature must come from a
+// key the ring currently trusts.
+type SignedRotation struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []KeySignature  `json:"signatures"`
+}
+
+type KeySignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type keyRingFile struct {
+	Version int64        `json:"version"`
+	Keys    []TrustedKey `json:"keys"`
+}
+
+// KeyRing holds the trusted signing keys. It implements SignatureVerifier
+// (any currently valid key) and KeyedVerifier (one named key).
+type KeyRing struct {
+	mu      sync.Mutex
+	keys    []TrustedKey
+	version int64
+	path    string
+
+	// Now is used for key validity windows; defaults to time.Now.
+	Now func() time.Time
+}
+
+func NewKeyRing(keys ...TrustedKey) (*KeyRing, error) {
+	r := &KeyRing{}
+	for _, k := range keys {
+		if err := r.add(k); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// LoadKeyRing reads a ring previously saved at path and adds any bootstrap
+// keys it does not know yet. Keys learned later through ApplyRotation are
+// persisted back to path. A missing file is not an error.
+func LoadKeyRing(path string, bootstrap ...TrustedKey) (*KeyRing, error) {
+	r := &KeyRing{path: path}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var f keyRingFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("decode key ring %s: %w", path, err)
+		}
+		r.version = f.Version
+		for _, k := range f.Keys {
+			if err := r.add(k); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, k := range bootstrap {
+		if k.ID == "" {
+			k.ID = KeyID(k.PublicKey)
+		}
+		if r.find(k.ID) >= 0 {
+			// never let a bootstrap key undo a persisted revocation
+			continue
+		}
+		if err := r.add(k); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *KeyRing) Add(k TrustedKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.add(k)
+}
+
+func (r *KeyRing) Revoke(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.find(id); i >= 0 {
+		r.keys[i].Revoked = true
+	}
+}
+
+// Keys returns a copy of all keys, including expired and revoked ones.
+func (r *KeyRing) Keys() []TrustedKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TrustedKey(nil), r.keys...)
+}
+
+func (r *KeyRing) Verify(message, sig []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("bad ed25519 signature size: %d", len(sig))
+	}
+	now := r.now()
+	for _, k := range r.keys {
+		if k.validAt(now) && ed25519.Verify(k.PublicKey, message, sig) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+func (r *KeyRing) VerifyKey(keyID string, message, sig []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.verifyKey(keyID, message, sig)
+}
+
+// ApplyRotation verifies a SignedRotation against the current ring, then
+// adds its keys and applies its revocations.
+func (r *KeyRing) ApplyRotation(data []byte) error {
+	var sr SignedRotation
+	if err := json.Unmarshal(data, &sr); err != nil {
+		return fmt.Errorf("decode key rotation: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var doc RotationDoc
+	if err := json.Unmarshal(sr.Signed, &doc); err != nil {
+		return fmt.Errorf("decode key rotation: %w", err)
+	}
+	if doc.Version < r.version {
+		return fmt.Errorf("key rotation version %d is older than %d", doc.Version, r.version)
+	}
+	if doc.Version == r.version {
+		// already applied (its signer may be revoked by now); nothing to trust
+		return nil
+	}
+
+	trusted := false
+	for _, s := range sr.Signatures {
+		sig, err := DecodeSignature([]byte(s.Sig))
+		if err != nil {
+			continue
+		}
+		if r.verifyKey(s.KeyID, sr.Signed, sig) == nil {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return errors.New("key rotation is not signed by a trusted key")
+	}
+
+	prev := append([]TrustedKey(nil), r.keys...)
+	for _, k := range doc.Keys {
+		if k.ID == "" {
+			k.ID = KeyID(k.PublicKey)
+		}
+		if i := r.find(k.ID); i >= 0 {
+			if !r.keys[i].PublicKey.Equal(k.PublicKey) {
+				r.keys = prev
+				return fmt.Errorf("key rotation changes public key of %q", k.ID)
+			}
+			// a rotation may adjust the validity window but not un-revoke
+			k.Revoked = k.Revoked || r.keys[i].Revoked
+			r.keys[i] = k
+			continue
+		}
+		if err := r.add(k); err != nil {
+			r.keys = prev
+			return err
+		}
+	}
+	for _, id := range doc.Revoke {
+		if i := r.find(id); i >= 0 {
+			r.keys[i].Revoked = true
+		}
+	}
+	r.version = doc.Version
+
+	if r.path != "" {
+		return r.save(r.path)
+	}
+	return nil
+}
+
+// Path is where ApplyRotation persists the ring; empty for rings made with
+// NewKeyRing.
+func (r *KeyRing) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+// Save writes the ring to path (for rings made with NewKeyRing).
+func (r *KeyRing) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.save(path)
+}
+
+func (r *KeyRing) save(path string) error {
+	b, err := json.MarshalIndent(keyRingFile{Version: r.version, Keys: r.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (r *KeyRing) verifyKey(keyID string, message, sig []byte) error {
+	i := r.find(keyID)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	k := r.keys[i]
+	if !k.validAt(r.now()) {
+		return fmt.Errorf("signing key %q is revoked or outside its validity window", keyID)
+	}
+	if len(sig) != ed25519.SignatureSize || !ed25519.Verify(k.PublicKey, message, sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (r *KeyRing) add(k TrustedKey) error {
+	if len(k.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad ed25519 public key size: %d", len(k.PublicKey))
+	}
+	if k.ID == "" {
+		k.ID = KeyID(k.PublicKey)
+	}
+	if r.find(k.ID) >= 0 {
+		return fmt.Errorf("duplicate key id %q", k.ID)
+	}
+	r.keys = append(r.keys, k)
+	return nil
+}
+
+func (r *KeyRing) find(id string) int {
+	for i := range r.keys {
+		if r.keys[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *KeyRing) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
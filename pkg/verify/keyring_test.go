@@ -0,0 +1,277 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type signer struct {
+	id   string
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newSigner(t *testing.T, id string) signer {
+	pub, priv := newKey(t)
+	return signer{id, pub, priv}
+}
+
+func (s signer) trusted() TrustedKey { return TrustedKey{ID: s.id, PublicKey: s.pub} }
+
+// rotation builds a SignedRotation for doc signed by each of by.
+func rotation(t *testing.T, doc RotationDoc, by ...signer) []byte {
+	t.Helper()
+	signed, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := SignedRotation{Signed: signed}
+	for _, s := range by {
+		sig := ed25519.Sign(s.priv, signed)
+		sr.Signatures = append(sr.Signatures, KeySignature{KeyID: s.id, Sig: base64.StdEncoding.EncodeToString(sig)})
+	}
+	b, err := json.Marshal(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKeyRingValidity(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	msg := []byte("manifest")
+	k := newSigner(t, "k")
+	sig := ed25519.Sign(k.priv, msg)
+
+	tests := []struct {
+		name string
+		key  TrustedKey
+		ok   bool
+	}{
+		{"no bounds", k.trusted(), true},
+		{"inside window", TrustedKey{ID: "k", PublicKey: k.pub, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, true},
+		{"not yet valid", TrustedKey{ID: "k", PublicKey: k.pub, NotBefore: now.Add(time.Hour)}, false},
+		{"expired", TrustedKey{ID: "k", PublicKey: k.pub, NotAfter: now.Add(-time.Hour)}, false},
+		{"expires now", TrustedKey{ID: "k", PublicKey: k.pub, NotAfter: now}, false},
+		{"revoked", TrustedKey{ID: "k", PublicKey: k.pub, Revoked: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewKeyRing(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Now = func() time.Time { return now }
+			if err := r.Verify(msg, sig); (err == nil) != tt.ok {
+				t.Errorf("Verify: %v, want ok=%v", err, tt.ok)
+			}
+			if err := r.VerifyKey("k", msg, sig); (err == nil) != tt.ok {
+				t.Errorf("VerifyKey: %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestKeyRingVerifyKey(t *testing.T) {
+	a, b := newSigner(t, "a"), newSigner(t, "b")
+	r, err := NewKeyRing(a.trusted(), b.trusted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("manifest")
+	sig := ed25519.Sign(a.priv, msg)
+
+	checkErr(t, r.VerifyKey("a", msg, sig), nil)
+	checkErr(t, r.VerifyKey("b", msg, sig), ErrSignatureInvalid) // only the named key counts
+	checkErr(t, r.VerifyKey("c", msg, sig), ErrUnknownKey)
+	checkErr(t, r.Verify(msg, sig), nil)
+
+	r.Revoke("a")
+	checkErr(t, r.VerifyKey("a", msg, sig), errAny)
+	checkErr(t, r.Verify(msg, sig), ErrSignatureInvalid)
+}
+
+func TestKeyRingAdd(t *testing.T) {
+	a := newSigner(t, "a")
+	if _, err := NewKeyRing(a.trusted(), a.trusted()); err == nil {
+		t.Error("duplicate id: want error")
+	}
+	if _, err := NewKeyRing(TrustedKey{ID: "x", PublicKey: a.pub[:10]}); err == nil {
+		t.Error("short key: want error")
+	}
+	r, err := NewKeyRing(TrustedKey{PublicKey: a.pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Keys()[0].ID; got != KeyID(a.pub) {
+		t.Errorf("derived id = %q, want %q", got, KeyID(a.pub))
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	root, next, rogue := newSigner(t, "root"), newSigner(t, "next"), newSigner(t, "rogue")
+	msg := []byte("manifest")
+	nextSig := ed25519.Sign(next.priv, msg)
+
+	tests := []struct {
+		name     string
+		setup    func(*KeyRing) // applied before the rotation under test
+		doc      []byte
+		wantErr  bool
+		nextOK   bool // whether next may sign afterwards
+		wantVers int64
+	}{
+		{
+			name:     "adds key",
+			doc:      rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, root),
+			nextOK:   true,
+			wantVers: 1,
+		},
+		{
+			name:     "any trusted signature suffices",
+			doc:      rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, rogue, root),
+			nextOK:   true,
+			wantVers: 1,
+		},
+		{
+			name:    "unsigned",
+			doc:     rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}),
+			wantErr: true,
+		},
+		{
+			name:    "signed by untrusted key",
+			doc:     rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, rogue),
+			wantErr: true,
+		},
+		{
+			name:    "signed by the key it adds",
+			doc:     rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, next),
+			wantErr: true,
+		},
+		{
+			name: "signed by revoked key",
+			setup: func(r *KeyRing) {
+				r.Revoke("root")
+			},
+			doc:     rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, root),
+			wantErr: true,
+		},
+		{
+			name:    "changes a known key",
+			doc:     rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{{ID: "root", PublicKey: rogue.pub}}}, root),
+			wantErr: true,
+		},
+		{
+			name: "replayed older version",
+			setup: func(r *KeyRing) {
+				mustRotate(t, r, rotation(t, RotationDoc{Version: 2}, root))
+			},
+			doc:      rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, root),
+			wantErr:  true,
+			wantVers: 2,
+		},
+		{
+			name: "same version again is a no-op",
+			setup: func(r *KeyRing) {
+				mustRotate(t, r, rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}, Revoke: []string{"next"}}, root))
+			},
+			doc:      rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, root),
+			wantVers: 1,
+		},
+		{
+			name: "revokes key",
+			setup: func(r *KeyRing) {
+				mustRotate(t, r, rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}}, root))
+			},
+			doc:      rotation(t, RotationDoc{Version: 2, Revoke: []string{"next"}}, root),
+			wantVers: 2,
+		},
+		{
+			name: "cannot un-revoke",
+			setup: func(r *KeyRing) {
+				mustRotate(t, r, rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}, Revoke: []string{"next"}}, root))
+			},
+			doc:      rotation(t, RotationDoc{Version: 2, Keys: []TrustedKey{next.trusted()}}, root),
+			wantVers: 2,
+		},
+		{
+			name:    "not json",
+			doc:     []byte("{"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewKeyRing(root.trusted())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			err = r.ApplyRotation(tt.doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyRotation: %v, want error=%v", err, tt.wantErr)
+			}
+			if ok := r.VerifyKey("next", msg, nextSig) == nil; ok != tt.nextOK {
+				t.Errorf("next key accepted = %v, want %v", ok, tt.nextOK)
+			}
+			if r.version != tt.wantVers {
+				t.Errorf("version = %d, want %d", r.version, tt.wantVers)
+			}
+		})
+	}
+}
+
+func mustRotate(t *testing.T, r *KeyRing, doc []byte) {
+	t.Helper()
+	if err := r.ApplyRotation(doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeyRingPersistsRotation(t *testing.T) {
+	root, next := newSigner(t, "root"), newSigner(t, "next")
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	r, err := LoadKeyRing(path, root.trusted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRotate(t, r, rotation(t, RotationDoc{Version: 1, Keys: []TrustedKey{next.trusted()}, Revoke: []string{"root"}}, root))
+
+	// the bootstrap key is passed again but must stay revoked
+	r, err = LoadKeyRing(path, root.trusted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("manifest")
+	checkErr(t, r.VerifyKey("next", msg, ed25519.Sign(next.priv, msg)), nil)
+	checkErr(t, r.Verify(msg, ed25519.Sign(root.priv, msg)), ErrSignatureInvalid)
+
+	// an old rotation cannot be replayed after a restart
+	if err := r.ApplyRotation(rotation(t, RotationDoc{Version: 0, Keys: []TrustedKey{root.trusted()}}, next)); err == nil {
+		t.Fatal("replayed rotation accepted")
+	}
+	if r.version != 1 {
+		t.Fatalf("version = %d, want 1", r.version)
+	}
+}
+
+func TestLoadKeyRingMissingFile(t *testing.T) {
+	root := newSigner(t, "root")
+	r, err := LoadKeyRing(filepath.Join(t.TempDir(), "missing.json"), root.trusted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Keys()) != 1 {
+		t.Fatalf("keys = %v", r.Keys())
+	}
+	if _, err := LoadKeyRing(t.TempDir()); err == nil {
+		t.Fatal("directory as key ring: want error")
+	}
+}